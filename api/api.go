@@ -0,0 +1,133 @@
+// Package api holds the request and response bodies of the Team To-Do HTTP
+// API. The server encodes these types and the client package decodes them, so
+// both sides always agree on the wire format.
+package api
+
+// Path prefix of the current API version
+const Prefix = "/api/v1"
+
+// Name of the session cookie set by POST /login
+const SessionCookie = "todo-session"
+
+type Todo struct {
+	ID              int       `json:"id"`
+	Text            string    `json:"text"`
+	Completed       bool      `json:"completed"`
+	UserID          int       `json:"user_id"`            // Assignee
+	User            string    `json:"user"`               // Assignee's current username
+	Assignee        *TodoUser `json:"assignee,omitempty"` // Assignee's profile
+	CreatedAt       string    `json:"created_at"`
+	CreatedByID     int       `json:"created_by_id,omitempty"`
+	CreatedBy       string    `json:"created_by,omitempty"` // Creator's current username
+	DueDate         string    `json:"due_date,omitempty"`   // YYYY-MM-DD
+	Tags            []string  `json:"tags,omitempty"`
+	DeletedAt       string    `json:"deleted_at,omitempty"`        // Set while the todo is in the trash
+	DeletedWithUser bool      `json:"deleted_with_user,omitempty"` // Trashed along with its user, restored with them
+}
+
+// The user a todo is assigned to, as shown with the todo
+type TodoUser struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// Body of POST /todos
+type NewTodo struct {
+	Text    string   `json:"text"`
+	User    string   `json:"user,omitempty"` // Defaults to the caller
+	DueDate string   `json:"due_date,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// A user as the API shows it, without credentials or secrets
+type User struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`               // Login handle, unique regardless of case
+	DisplayName  string `json:"display_name,omitempty"` // Full name to show, in any script
+	Role         string `json:"role"`
+	Email        string `json:"email,omitempty"`
+	AvatarURL    string `json:"avatar_url,omitempty"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPRequired bool   `json:"totp_required"`
+}
+
+// Body of POST /admin/users
+type CreateUserRequest struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	Role        string `json:"role,omitempty"` // Defaults to "user"
+	DisplayName string `json:"display_name,omitempty"`
+	Email       string `json:"email,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// Body of PUT /admin/users/{id}
+type UpdateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"` // Left unchanged when empty
+	Role     string `json:"role"`
+
+	// Left unchanged when nil; an empty string clears them
+	DisplayName *string `json:"display_name,omitempty"`
+	Email       *string `json:"email,omitempty"`
+	AvatarURL   *string `json:"avatar_url,omitempty"`
+}
+
+// Response of DELETE /admin/users/{id}
+type DeleteUserResponse struct {
+	Message         string `json:"message"`
+	TrashedTodos    int    `json:"trashed_todos,omitempty"`
+	ReassignedTodos int    `json:"reassigned_todos,omitempty"`
+	ReassignedTo    string `json:"reassigned_to,omitempty"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Body of POST /login/2fa; set one of the two
+type TwoFactorLoginRequest struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// Response of POST /login and POST /login/2fa. When TwoFactorRequired is set
+// the session is not logged in until POST /login/2fa succeeds.
+type LoginResponse struct {
+	Message                string     `json:"message"`
+	TwoFactorRequired      bool       `json:"two_factor_required,omitempty"`
+	User                   *LoginUser `json:"user,omitempty"`
+	Permissions            []string   `json:"permissions,omitempty"`
+	TwoFactorSetupRequired bool       `json:"two_factor_setup_required,omitempty"`
+	RecoveryCodesRemaining *int       `json:"recovery_codes_remaining,omitempty"` // Set by POST /login/2fa
+}
+
+type LoginUser struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Role        string `json:"role"`
+}
+
+// Response of GET /me
+type CurrentUser struct {
+	ID          int      `json:"id"`
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name,omitempty"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// Body of POST /update-password
+type UpdatePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// Plain confirmation returned by endpoints with nothing else to report
+type Message struct {
+	Message string `json:"message"`
+}
@@ -0,0 +1,46 @@
+package api
+
+// Machine-readable error codes, stable across releases
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidJSON        = "invalid_json"
+	CodeInvalidID          = "invalid_id"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeGone               = "gone"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeRateLimited        = "rate_limited"
+	CodeBatchFailed        = "batch_failed"
+	CodeImportFailed       = "import_failed"
+	CodeUpstreamError      = "upstream_error"
+	CodeInternalError      = "internal_error"
+)
+
+// Content type of every error response
+const ProblemContentType = "application/problem+json"
+
+// A problem detail (RFC 9457) with this API's extension members. Every error
+// response, from handlers and middleware alike, has this shape.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// One invalid field. Field is the JSON name of the request field, with an index
+// for list items, e.g. "operations[0].ids[2]".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"to-do-list/api"
+	"to-do-list/client"
+)
+
+// The Go client against the real handlers, so the shared api types can't drift
+// from what the server sends and accepts
+func TestClientAgainstServer(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	srv := httptest.NewServer(newRouter())
+	defer srv.Close()
+	ctx := context.Background()
+
+	admin, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Login(ctx, "admin", "wrong"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("login with a wrong password: %v, want ErrUnauthorized", err)
+	}
+	if _, err := admin.Login(ctx, "admin", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := admin.CreateUser(ctx, api.CreateUserRequest{
+		Username:    "dana",
+		Password:    "Correct-horse-9",
+		DisplayName: "Dana Scully",
+		Email:       "dana@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Username != "dana" || created.Role != "user" || created.DisplayName != "Dana Scully" || created.Email != "dana@example.com" {
+		t.Fatalf("created %+v", created)
+	}
+	if _, err := admin.CreateUser(ctx, api.CreateUserRequest{Username: "DANA", Password: "Correct-horse-9"}); !errors.Is(err, client.ErrConflict) {
+		t.Fatalf("creating a duplicate username: %v, want ErrConflict", err)
+	}
+	_, err = admin.CreateUser(ctx, api.CreateUserRequest{Username: "x y", Password: "short"})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrValidation) || len(apiErr.Errors) != 2 {
+		t.Fatalf("creating an invalid user: %v, want username and password field errors", err)
+	}
+
+	// Log in as the new user with a second client and its token
+	dana, _ := client.New(srv.URL)
+	if _, err := dana.Login(ctx, "dana", "Correct-horse-9"); err != nil {
+		t.Fatal(err)
+	}
+	resumed, _ := client.New(srv.URL, client.WithToken(dana.Token()))
+	me, err := resumed.Me(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me.ID != created.ID || me.DisplayName != "Dana Scully" {
+		t.Fatalf("me %+v", me)
+	}
+
+	todo, err := resumed.CreateTodo(ctx, api.NewTodo{Text: "Write the report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.UserID != created.ID || todo.CreatedByID != created.ID {
+		t.Fatalf("created todo %+v", todo)
+	}
+	if err := resumed.CompleteTodo(ctx, todo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resumed.ListUsers(ctx); err != nil {
+		t.Fatalf("listing users as a user: %v", err)
+	}
+	if _, err := resumed.CreateUser(ctx, api.CreateUserRequest{Username: "eve", Password: "Correct-horse-9"}); !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("creating a user without users:manage: %v, want ErrForbidden", err)
+	}
+
+	deleted, err := admin.DeleteUser(ctx, created.ID, "Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.ReassignedTo != "alice" || deleted.ReassignedTodos != 1 || deleted.TrashedTodos != 0 {
+		t.Fatalf("delete response %+v", deleted)
+	}
+	todos, err := admin.ListTodos(ctx, client.TodoFilter{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todos) != 1 || todos[0].ID != todo.ID || !todos[0].Completed {
+		t.Fatalf("alice's todos %+v, want the reassigned todo", todos)
+	}
+	if _, err := resumed.ListTodos(ctx, client.TodoFilter{}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("deleted user's session: %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"to-do-list/api"
+)
+
+// Batch operations
+const (
+	BatchComplete = "complete"
+	BatchReopen   = "reopen"
+	BatchDelete   = "delete"
+	BatchReassign = "reassign"
+	BatchTag      = "tag"
+)
+
+// Batch modes
+const (
+	BatchAtomic     = "atomic"      // Apply everything or nothing
+	BatchBestEffort = "best_effort" // Apply whatever succeeds
+)
+
+const (
+	maxBatchItems = 1000
+	maxTags       = 20
+	maxTagLength  = 30
+)
+
+type BatchOperation struct {
+	Op   string   `json:"op"`
+	IDs  []int    `json:"ids"`
+	User string   `json:"user,omitempty"` // reassign
+	Tags []string `json:"tags,omitempty"` // tag
+}
+
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchItemResult struct {
+	Op     string `json:"op"`
+	ID     int    `json:"id"`
+	OK     bool   `json:"ok"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Mode      string            `json:"mode"`
+	Applied   bool              `json:"applied"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// Trim, lowercase and de-duplicate tags; returns an error message for invalid ones
+func normalizeTags(tags []string) ([]string, string) {
+	var result []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, "Tags cannot be empty"
+		}
+		if len([]rune(tag)) > maxTagLength {
+			return nil, fmt.Sprintf("Tags must be %d characters or less", maxTagLength)
+		}
+		for _, char := range tag {
+			if !unicode.IsLetter(char) && !unicode.IsDigit(char) && char != '-' && char != '_' {
+				return nil, "Tags can only contain letters, numbers, '-' and '_'"
+			}
+		}
+		if !containsString(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	if len(result) > maxTags {
+		return nil, fmt.Sprintf("A todo can have at most %d tags", maxTags)
+	}
+	return result, ""
+}
+
+// A change to report once the batch is committed
+type batchEffect struct {
+	op       string
+	id       int
+	previous Todo
+}
+
+// Apply one operation to a todo in the working copy, with the same permission
+// checks as the single-item handlers. Returns the HTTP status and error message.
+func applyBatchOperation(r *http.Request, working []Todo, op BatchOperation, id int) (int, string) {
+	var todo *Todo
+	for i := range working {
+		if working[i].ID == id && working[i].DeletedAt == "" {
+			todo = &working[i]
+			break
+		}
+	}
+	if todo == nil {
+		return http.StatusNotFound, "Todo not found"
+	}
+
+	switch op.Op {
+	case BatchComplete, BatchReopen:
+		if !canActOnTodo(r, todo.UserID, PermTodosCompleteOwn, PermTodosCompleteAny) {
+			return http.StatusForbidden, "Forbidden - cannot change this todo"
+		}
+		todo.Completed = op.Op == BatchComplete
+
+	case BatchDelete:
+		if !canActOnTodo(r, todo.UserID, PermTodosDeleteOwn, PermTodosDeleteAny) {
+			return http.StatusForbidden, "Forbidden - cannot delete this todo"
+		}
+		todo.DeletedAt = time.Now().Format(timestampFormat)
+
+	case BatchReassign:
+		// Moving a todo needs the right to create todos for both users
+		assignee := findUserByUsername(r.Context(), op.User)
+		if !canActOnTodo(r, todo.UserID, PermTodosCreateOwn, PermTodosCreateAny) ||
+			!canActOnTodo(r, assignee.ID, PermTodosCreateOwn, PermTodosCreateAny) {
+			return http.StatusForbidden, "Forbidden - cannot reassign this todo"
+		}
+		assignTodo(todo, assignee)
+
+	case BatchTag:
+		if !canActOnTodo(r, todo.UserID, PermTodosCompleteOwn, PermTodosCompleteAny) {
+			return http.StatusForbidden, "Forbidden - cannot change this todo"
+		}
+		tags, msg := normalizeTags(append(append([]string(nil), todo.Tags...), op.Tags...))
+		if msg != "" {
+			return http.StatusBadRequest, msg
+		}
+		todo.Tags = tags
+	}
+	return http.StatusOK, ""
+}
+
+// Check the parts of an operation that don't depend on the todo
+func validateBatchOperation(op *BatchOperation) string {
+	switch op.Op {
+	case BatchComplete, BatchReopen, BatchDelete:
+	case BatchReassign:
+		if msg := checkAssignee(op.User); msg != "" {
+			return msg
+		}
+	case BatchTag:
+		tags, msg := normalizeTags(op.Tags)
+		if msg != "" {
+			return msg
+		}
+		if len(tags) == 0 {
+			return "At least one tag is required"
+		}
+		op.Tags = tags
+	default:
+		return fmt.Sprintf("Unknown operation %q", op.Op)
+	}
+	return ""
+}
+
+// A failed atomic batch: the problem, plus the result of every item so the
+// client can see which ones failed and which would have been applied
+type batchFailure struct {
+	*api.Problem
+	BatchResponse
+}
+
+// A failed atomic batch as a problem, with a field error for each item that failed.
+// Field paths point into the request, e.g. "operations[0].ids[2]".
+func batchProblem(operations []BatchOperation, results []BatchItemResult, failed int) *api.Problem {
+	detail := fmt.Sprintf("%d of %d items failed. Nothing was applied.", failed, len(results))
+	problem := newProblem(http.StatusUnprocessableEntity, api.CodeBatchFailed, detail)
+	n := 0
+	for i, op := range operations {
+		for j := range op.IDs {
+			if result := results[n]; !result.OK {
+				problem.Errors = append(problem.Errors, api.FieldError{
+					Field:   fmt.Sprintf("operations[%d].ids[%d]", i, j),
+					Code:    batchItemCode(result.Status),
+					Message: fmt.Sprintf("Todo %d: %s", result.ID, result.Error),
+				})
+			}
+			n++
+		}
+	}
+	return problem
+}
+
+func batchItemCode(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return api.CodeNotFound
+	case http.StatusForbidden:
+		return api.CodeForbidden
+	}
+	return api.CodeValidationFailed
+}
+
+// POST /todos/batch — Apply operations to many todos, atomically or best-effort
+func batchTodos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req BatchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = BatchAtomic
+	}
+	if req.Mode != BatchAtomic && req.Mode != BatchBestEffort {
+		writeFieldError(w, r, "mode", "Mode must be atomic or best_effort")
+		return
+	}
+
+	// Validation reads the users, so the lock is taken before it. It is
+	// released before the results, which can be long, are written out.
+	dataMu.Lock()
+	itemCount := 0
+	for i := range req.Operations {
+		itemCount += len(req.Operations[i].IDs)
+		if msg := validateBatchOperation(&req.Operations[i]); msg != "" {
+			dataMu.Unlock()
+			writeFieldError(w, r, fmt.Sprintf("operations[%d]", i), fmt.Sprintf("Operation %d: %s", i+1, msg))
+			return
+		}
+	}
+	if itemCount == 0 {
+		dataMu.Unlock()
+		writeFieldError(w, r, "operations", "No todos to change")
+		return
+	}
+	if itemCount > maxBatchItems {
+		dataMu.Unlock()
+		writeFieldError(w, r, "operations", fmt.Sprintf("A batch can change at most %d todos", maxBatchItems))
+		return
+	}
+
+	// Work on a copy so an atomic batch can be thrown away if any item fails
+	working := append([]Todo(nil), todos...)
+	response := BatchResponse{Mode: req.Mode, Results: []BatchItemResult{}}
+	var effects []batchEffect
+
+	for _, op := range req.Operations {
+		for _, id := range op.IDs {
+			var previous Todo
+			for _, t := range working {
+				if t.ID == id {
+					previous = t
+				}
+			}
+			status, msg := applyBatchOperation(r, working, op, id)
+			result := BatchItemResult{Op: op.Op, ID: id, OK: status == http.StatusOK, Status: status, Error: msg}
+			if result.OK {
+				response.Succeeded++
+				effects = append(effects, batchEffect{op: op.Op, id: id, previous: previous})
+			} else {
+				response.Failed++
+			}
+			response.Results = append(response.Results, result)
+		}
+	}
+
+	if req.Mode == BatchAtomic && response.Failed > 0 {
+		dataMu.Unlock()
+		failure := batchFailure{batchProblem(req.Operations, response.Results, response.Failed), response}
+		writeProblemBody(w, r, failure.Problem, failure)
+		return
+	}
+
+	todos = working
+	response.Applied = response.Succeeded > 0
+	announceBatchEffects(r.Header.Get("X-User-Name"), effects)
+	dataMu.Unlock()
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// Send the events, webhooks and notifications the single-item handlers would have sent
+func announceBatchEffects(actor string, effects []batchEffect) {
+	current := func(id int) Todo {
+		for _, t := range todos {
+			if t.ID == id {
+				return t
+			}
+		}
+		return Todo{}
+	}
+
+	for _, effect := range effects {
+		todo := current(effect.id)
+		switch effect.op {
+		case BatchComplete:
+			if effect.previous.Completed {
+				continue
+			}
+			publishTodo(EventTodoUpdated, todo)
+			emitWebhook(WebhookTodoCompleted, todo)
+			notifyUser(effect.previous.UserID, NotifyCompleted, actor, notificationData{Todo: todo})
+			if effect.previous.CreatedByID != effect.previous.UserID {
+				notifyUser(effect.previous.CreatedByID, NotifyCompleted, actor, notificationData{Todo: todo})
+			}
+		case BatchDelete:
+			publishTodo(EventTodoDeleted, todo)
+			emitWebhook(WebhookTodoDeleted, todo)
+		case BatchReopen:
+			delete(remindersSent, todo.ID)
+			publishTodo(EventTodoUpdated, todo)
+		case BatchReassign:
+			publishTodo(EventTodoUpdated, todo)
+			if effect.previous.UserID != todo.UserID {
+				notifyUser(todo.UserID, NotifyAssigned, actor, notificationData{Todo: todo})
+			}
+		default:
+			publishTodo(EventTodoUpdated, todo)
+		}
+	}
+}
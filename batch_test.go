@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"to-do-list/api"
+)
+
+// Two todos for alice and one for bob
+func useBatchTodos(t *testing.T) http.Handler {
+	t.Helper()
+	useTestData(t)
+	useDefaultRoles(t)
+	useRateLimits(t, map[string]RateLimit{})
+	todos = []Todo{
+		{ID: 1, Text: "Alice's first", UserID: 2, User: "alice", CreatedByID: 2},
+		{ID: 2, Text: "Alice's second", UserID: 2, User: "alice", CreatedByID: 2},
+		{ID: 3, Text: "Bob's", UserID: 3, User: "bob", CreatedByID: 3},
+	}
+	nextID = 4
+	return newRouter()
+}
+
+type batchResult struct {
+	api.Problem
+	BatchResponse
+}
+
+func sendBatch(t *testing.T, handler http.Handler, cookies []*http.Cookie, body string, wantStatus int) batchResult {
+	t.Helper()
+	rec := doJSON(t, handler, http.MethodPost, "/api/v1/todos/batch", body, cookies)
+	if rec.Code != wantStatus {
+		t.Fatalf("batch: status %d, want %d: %s", rec.Code, wantStatus, rec.Body)
+	}
+	var result batchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("batch response: %v", err)
+	}
+	return result
+}
+
+// The HTTP status of each item, in request order
+func itemStatuses(results []BatchItemResult) []int {
+	statuses := make([]int, len(results))
+	for i, result := range results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+func TestAtomicBatchAppliesNothingWhenAnItemFails(t *testing.T) {
+	router := useBatchTodos(t)
+	alice := logIn(t, router, "alice", "password123")
+	before := append([]Todo(nil), todos...)
+
+	result := sendBatch(t, router, alice, `{"operations":[{"op":"complete","ids":[1,3,2]}]}`, http.StatusUnprocessableEntity)
+
+	if result.Code != api.CodeBatchFailed || result.Mode != BatchAtomic || result.Applied {
+		t.Errorf("code %q, mode %q, applied %v; want a failed atomic batch", result.Code, result.Mode, result.Applied)
+	}
+	if got, want := itemStatuses(result.Results), []int{200, 403, 200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("item statuses %v, want %v", got, want)
+	}
+	if result.Results[1].OK || result.Results[1].ID != 3 || result.Succeeded != 2 || result.Failed != 1 {
+		t.Errorf("results %+v, want todo 3 marked as failed", result.Results)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Field != "operations[0].ids[1]" || result.Errors[0].Code != api.CodeForbidden {
+		t.Errorf("field errors %+v, want one for operations[0].ids[1]", result.Errors)
+	}
+	if !reflect.DeepEqual(todos, before) {
+		t.Errorf("todos changed by a failed atomic batch: %+v", todos)
+	}
+}
+
+func TestBestEffortBatchAppliesWhatItCan(t *testing.T) {
+	router := useBatchTodos(t)
+	alice := logIn(t, router, "alice", "password123")
+
+	result := sendBatch(t, router, alice, `{"mode":"best_effort","operations":[{"op":"complete","ids":[1,3,99]}]}`, http.StatusOK)
+
+	if got, want := itemStatuses(result.Results), []int{200, 403, 404}; !reflect.DeepEqual(got, want) {
+		t.Errorf("item statuses %v, want %v", got, want)
+	}
+	if !result.Applied || result.Succeeded != 1 || result.Failed != 2 {
+		t.Errorf("applied %v, %d succeeded, %d failed; want 1 applied", result.Applied, result.Succeeded, result.Failed)
+	}
+	if !todos[0].Completed || todos[2].Completed {
+		t.Errorf("completed: todo 1 %v, todo 3 %v; want only todo 1", todos[0].Completed, todos[2].Completed)
+	}
+}
+
+func TestBatchChecksPermissionsPerItem(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		body     string
+		want     []int
+	}{
+		// The user role can complete its own todos but not anyone else's
+		{"complete own and any", "alice", "password123", `{"op":"complete","ids":[1,3]}`, []int{200, 403}},
+		{"tag own and any", "alice", "password123", `{"op":"tag","ids":[2,3],"tags":["home"]}`, []int{200, 403}},
+		// and it has no delete permission at all
+		{"delete own", "alice", "password123", `{"op":"delete","ids":[1]}`, []int{403}},
+		// Reassigning needs the right to create todos for both users
+		{"reassign own to someone else", "alice", "password123", `{"op":"reassign","ids":[1],"user":"bob"}`, []int{403}},
+		{"complete any as admin", "admin", "admin", `{"op":"complete","ids":[1,3]}`, []int{200, 200}},
+		{"delete any as admin", "admin", "admin", `{"op":"delete","ids":[2,3]}`, []int{200, 200}},
+		{"reassign any as admin", "admin", "admin", `{"op":"reassign","ids":[3],"user":"charlie"}`, []int{200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := useBatchTodos(t)
+			cookies := logIn(t, router, tt.username, tt.password)
+
+			result := sendBatch(t, router, cookies, `{"mode":"best_effort","operations":[`+tt.body+`]}`, http.StatusOK)
+			if got := itemStatuses(result.Results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("item statuses %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchAppliesOpsOnTheSameTodoInOrder(t *testing.T) {
+	ops := `"operations":[
+		{"op":"tag","ids":[1,1],"tags":["home"]},
+		{"op":"complete","ids":[1]},
+		{"op":"delete","ids":[1]},
+		{"op":"reopen","ids":[1]}]`
+
+	t.Run("best effort", func(t *testing.T) {
+		router := useBatchTodos(t)
+		admin := logIn(t, router, "admin", "admin")
+
+		result := sendBatch(t, router, admin, `{"mode":"best_effort",`+ops+`}`, http.StatusOK)
+		// Each op sees the todo as the ones before it left it, so it is gone by the reopen
+		if got, want := itemStatuses(result.Results), []int{200, 200, 200, 200, 404}; !reflect.DeepEqual(got, want) {
+			t.Errorf("item statuses %v, want %v", got, want)
+		}
+		todo := todos[0]
+		if !reflect.DeepEqual(todo.Tags, []string{"home"}) || !todo.Completed || todo.DeletedAt == "" {
+			t.Errorf("todo 1 %+v, want tagged once, completed and in the trash", todo)
+		}
+	})
+
+	t.Run("atomic", func(t *testing.T) {
+		router := useBatchTodos(t)
+		admin := logIn(t, router, "admin", "admin")
+		before := append([]Todo(nil), todos...)
+
+		result := sendBatch(t, router, admin, `{`+ops+`}`, http.StatusUnprocessableEntity)
+		if len(result.Errors) != 1 || result.Errors[0].Field != "operations[3].ids[0]" {
+			t.Errorf("field errors %+v, want one for operations[3].ids[0]", result.Errors)
+		}
+		if !reflect.DeepEqual(todos, before) {
+			t.Errorf("todos changed by a failed atomic batch: %+v", todos)
+		}
+	})
+}
+
+func TestBatchRejectsMistypedFields(t *testing.T) {
+	router := useBatchTodos(t)
+	alice := logIn(t, router, "alice", "password123")
+
+	result := sendBatch(t, router, alice, `{"mode":true,"operations":[{"op":"complete","ids":[1]}]}`, http.StatusBadRequest)
+	if result.Code != api.CodeValidationFailed || len(result.Errors) != 1 || result.Errors[0].Field != "mode" {
+		t.Errorf("problem %+v, want a field error for mode", result.Problem)
+	}
+}
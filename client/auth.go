@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"to-do-list/api"
+)
+
+// Login starts a session. For accounts with two-factor authentication it
+// returns ErrTwoFactorRequired; finish with LoginSecondFactor on the same Client.
+func (c *Client) Login(ctx context.Context, username, password string) (*api.LoginResponse, error) {
+	var resp api.LoginResponse
+	if err := c.do(ctx, http.MethodPost, "/login", nil, api.LoginRequest{Username: username, Password: password}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.TwoFactorRequired {
+		return &resp, ErrTwoFactorRequired
+	}
+	return &resp, nil
+}
+
+// LoginSecondFactor completes a login with an authenticator or recovery code
+func (c *Client) LoginSecondFactor(ctx context.Context, req api.TwoFactorLoginRequest) (*api.LoginResponse, error) {
+	var resp api.LoginResponse
+	if err := c.do(ctx, http.MethodPost, "/login/2fa", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Logout ends the session
+func (c *Client) Logout(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/logout", nil, nil, nil)
+}
+
+// Me returns the logged-in user and their permissions
+func (c *Client) Me(ctx context.Context) (*api.CurrentUser, error) {
+	var user api.CurrentUser
+	if err := c.do(ctx, http.MethodGet, "/me", nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdatePassword changes the logged-in user's password
+func (c *Client) UpdatePassword(ctx context.Context, currentPassword, newPassword string) error {
+	req := api.UpdatePasswordRequest{CurrentPassword: currentPassword, NewPassword: newPassword}
+	return c.do(ctx, http.MethodPost, "/update-password", nil, req, nil)
+}
@@ -0,0 +1,129 @@
+// Package client is a Go client for the Team To-Do API.
+//
+//	c, err := client.New("http://localhost:8080")
+//	if err != nil { ... }
+//	if _, err := c.Login(ctx, "alice", "password123"); err != nil { ... }
+//	todos, err := c.ListTodos(ctx, client.TodoFilter{})
+//
+// A Client keeps its session in a cookie jar. Token returns the session so it
+// can be stored and handed back later with WithToken, skipping the login.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"to-do-list/api"
+)
+
+type Client struct {
+	base  *url.URL
+	http  *http.Client
+	token string // From WithToken, put in the jar by New
+}
+
+type Option func(*Client)
+
+// Use hc for requests instead of a fresh http.Client. A cookie jar is added if
+// hc has none, since the session lives in a cookie.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// Resume a session from a token returned by Token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New returns a client for the server at baseURL, e.g. "http://localhost:8080"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid base URL: %w", err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("client: base URL must be an absolute http or https URL, got %q", baseURL)
+	}
+
+	c := &Client{base: base, http: &http.Client{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.http.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		c.http.Jar = jar
+	}
+	if c.token != "" {
+		c.http.Jar.SetCookies(c.base, []*http.Cookie{{Name: api.SessionCookie, Value: c.token, Path: "/"}})
+		c.token = ""
+	}
+	return c, nil
+}
+
+// Token returns the current session, or "" when not logged in. Treat it like a
+// password: anyone holding it is logged in as you until it expires.
+func (c *Client) Token() string {
+	for _, cookie := range c.http.Jar.Cookies(c.base) {
+		if cookie.Name == api.SessionCookie {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// Send a request to the API and decode a JSON response into out, if given.
+// Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	target := *c.base
+	target.Path = c.base.Path + api.Prefix + path
+	target.RawQuery = query.Encode()
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("client: encoding request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errorFromResponse(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"to-do-list/api"
+)
+
+func TestNewRejectsRelativeURLs(t *testing.T) {
+	for _, base := range []string{"", "localhost:8080", "/api", "ftp://example.com"} {
+		if _, err := New(base); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", base)
+		}
+	}
+	if _, err := New("https://todo.example.com/team/"); err != nil {
+		t.Errorf("New with a path prefix: %v", err)
+	}
+}
+
+// A server that records the last request and answers with a fixed response
+type recordingServer struct {
+	*httptest.Server
+	method, path, query, cookie string
+	body                        []byte
+}
+
+func newRecordingServer(t *testing.T, status int, header http.Header, response string) *recordingServer {
+	t.Helper()
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.method, s.path, s.query = r.Method, r.URL.Path, r.URL.RawQuery
+		if c, err := r.Cookie(api.SessionCookie); err == nil {
+			s.cookie = c.Value
+		}
+		s.body, _ = io.ReadAll(r.Body)
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestRequestsGoUnderTheAPIPrefix(t *testing.T) {
+	s := newRecordingServer(t, http.StatusOK, nil, `{"message":"User deleted successfully","reassigned_todos":3,"reassigned_to":"bob"}`)
+	c, err := New(s.URL+"/team", WithToken("session-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.DeleteUser(context.Background(), 4, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.method != http.MethodDelete || s.path != "/team"+api.Prefix+"/admin/users/4" || s.query != "reassign_to=bob" {
+		t.Errorf("sent %s %s?%s", s.method, s.path, s.query)
+	}
+	if s.cookie != "session-token" {
+		t.Errorf("session cookie %q, want the token from WithToken", s.cookie)
+	}
+	if resp.ReassignedTodos != 3 || resp.ReassignedTo != "bob" {
+		t.Errorf("response %+v", resp)
+	}
+}
+
+func TestCreateUserSendsTheRequestType(t *testing.T) {
+	s := newRecordingServer(t, http.StatusCreated, nil, `{"id":5,"username":"dana","role":"user"}`)
+	c, _ := New(s.URL)
+
+	user, err := c.CreateUser(context.Background(), api.CreateUserRequest{Username: "dana", Password: "s3cret-pass", DisplayName: "Dana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent map[string]interface{}
+	if err := json.Unmarshal(s.body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"username": "dana", "password": "s3cret-pass", "display_name": "Dana"}
+	if len(sent) != len(want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+	for name, value := range want {
+		if sent[name] != value {
+			t.Errorf("sent %s = %v, want %v", name, sent[name], value)
+		}
+	}
+	if user.ID != 5 || user.Username != "dana" {
+		t.Errorf("created %+v", user)
+	}
+}
+
+func TestLoginReportsTwoFactorRequired(t *testing.T) {
+	s := newRecordingServer(t, http.StatusOK, nil, `{"message":"Two-factor authentication required","two_factor_required":true}`)
+	c, _ := New(s.URL)
+
+	resp, err := c.Login(context.Background(), "alice", "password123")
+	if !errors.Is(err, ErrTwoFactorRequired) {
+		t.Fatalf("error %v, want ErrTwoFactorRequired", err)
+	}
+	if resp == nil || !resp.TwoFactorRequired {
+		t.Fatalf("response %+v", resp)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		kind       error
+		code       string
+		requestID  string
+		retryAfter time.Duration
+	}{
+		{
+			name:      "problem",
+			status:    http.StatusNotFound,
+			body:      `{"title":"Not Found","status":404,"code":"not_found","detail":"Todo not found","request_id":"req-1"}`,
+			kind:      ErrNotFound,
+			code:      api.CodeNotFound,
+			requestID: "req-1",
+		},
+		{
+			name:   "field errors",
+			status: http.StatusBadRequest,
+			body:   `{"status":400,"code":"validation_failed","detail":"Text is required","errors":[{"field":"text","code":"validation_failed","message":"Text is required"}]}`,
+			kind:   ErrValidation,
+			code:   api.CodeValidationFailed,
+		},
+		{
+			name:       "rate limited",
+			status:     http.StatusTooManyRequests,
+			header:     http.Header{"Retry-After": {"7"}},
+			body:       `{"status":429,"code":"rate_limited","detail":"Too many write requests"}`,
+			kind:       ErrRateLimited,
+			code:       api.CodeRateLimited,
+			retryAfter: 7 * time.Second,
+		},
+		{
+			name:      "not a problem document",
+			status:    http.StatusForbidden,
+			header:    http.Header{"X-Request-Id": {"req-2"}},
+			body:      "blocked by proxy\n",
+			kind:      ErrForbidden,
+			requestID: "req-2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRecordingServer(t, tt.status, tt.header, tt.body)
+			c, _ := New(s.URL)
+
+			_, err := c.ListTodos(context.Background(), TodoFilter{})
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("error %v is not %v", err, tt.kind)
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error %T is not an *Error", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.code || apiErr.RequestID != tt.requestID || apiErr.RetryAfter != tt.retryAfter {
+				t.Errorf("got status %d, code %q, request %q, retry after %v", apiErr.StatusCode, apiErr.Code, apiErr.RequestID, apiErr.RetryAfter)
+			}
+			if tt.code == "" && apiErr.Detail != "blocked by proxy" {
+				t.Errorf("detail %q, want the response body", apiErr.Detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"to-do-list/api"
+)
+
+// Kinds of failure, for use with errors.Is:
+//
+//	if errors.Is(err, client.ErrNotFound) { ... }
+var (
+	ErrUnauthorized      = errors.New("not logged in or wrong credentials")
+	ErrForbidden         = errors.New("permission denied")
+	ErrNotFound          = errors.New("not found")
+	ErrConflict          = errors.New("conflicts with existing data")
+	ErrValidation        = errors.New("invalid request")
+	ErrTwoFactorRequired = errors.New("two-factor authentication code required")
+	ErrRateLimited       = errors.New("too many requests")
+)
+
+// An error response from the server. The embedded problem has the
+// machine-readable code, field errors and the request ID to quote in bug reports.
+type Error struct {
+	StatusCode int
+	RetryAfter time.Duration // How long to wait before retrying a rate-limited request
+	api.Problem
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Detail)
+	for _, field := range e.Errors {
+		if field.Message != e.Detail {
+			msg += fmt.Sprintf("; %s: %s", field.Field, field.Message)
+		}
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Is reports whether the error is of one of the kinds above
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrValidation:
+		return e.Code == api.CodeValidationFailed || e.Code == api.CodeInvalidJSON || e.Code == api.CodeInvalidID ||
+			e.StatusCode == http.StatusUnprocessableEntity
+	}
+	return false
+}
+
+// Build an *Error from a non-2xx response. Bodies that aren't problem documents,
+// e.g. from a proxy, are kept as the detail.
+func errorFromResponse(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	e := &Error{StatusCode: resp.StatusCode}
+	if err := json.Unmarshal(data, &e.Problem); err != nil || e.Code == "" {
+		e.Problem = api.Problem{
+			Status: resp.StatusCode,
+			Title:  http.StatusText(resp.StatusCode),
+			Detail: strings.TrimSpace(string(data)),
+		}
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"to-do-list/api"
+)
+
+// Narrows ListTodos. Without todos:read:any the server only returns your own todos.
+type TodoFilter struct {
+	User string // Only todos assigned to this user
+}
+
+// ListTodos returns the todos you can see, leaving out the trash
+func (c *Client) ListTodos(ctx context.Context, filter TodoFilter) ([]api.Todo, error) {
+	query := url.Values{}
+	if filter.User != "" {
+		query.Set("user", filter.User)
+	}
+	var todos []api.Todo
+	if err := c.do(ctx, http.MethodGet, "/todos", query, nil, &todos); err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+// CreateTodo adds a todo, assigned to you unless todo.User says otherwise
+func (c *Client) CreateTodo(ctx context.Context, todo api.NewTodo) (*api.Todo, error) {
+	var created api.Todo
+	if err := c.do(ctx, http.MethodPost, "/todos", nil, todo, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// CompleteTodo marks a todo as completed
+func (c *Client) CompleteTodo(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodPut, "/todos/"+strconv.Itoa(id)+"/complete", nil, nil, nil)
+}
+
+// DeleteTodo moves a todo to the trash
+func (c *Client) DeleteTodo(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/todos/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+// RestoreTodo brings a todo back from the trash
+func (c *Client) RestoreTodo(ctx context.Context, id int) (*api.Todo, error) {
+	var restored api.Todo
+	if err := c.do(ctx, http.MethodPost, "/todos/"+strconv.Itoa(id)+"/restore", nil, nil, &restored); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
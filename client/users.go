@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"to-do-list/api"
+)
+
+// ListUsers returns all active users. Needs users:read.
+func (c *Client) ListUsers(ctx context.Context) ([]api.User, error) {
+	var users []api.User
+	if err := c.do(ctx, http.MethodGet, "/admin/users", nil, nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CreateUser adds a user. Needs users:manage.
+func (c *Client) CreateUser(ctx context.Context, user api.CreateUserRequest) (*api.User, error) {
+	var created api.User
+	if err := c.do(ctx, http.MethodPost, "/admin/users", nil, user, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateUser replaces a user's username and role, and their password if one is
+// given. Needs users:manage.
+func (c *Client) UpdateUser(ctx context.Context, id int, update api.UpdateUserRequest) error {
+	return c.do(ctx, http.MethodPut, "/admin/users/"+strconv.Itoa(id), nil, update, nil)
+}
+
+// DeleteUser moves a user to the trash along with their todos, or hands the
+// todos to reassignTo when it is set. Needs users:manage.
+func (c *Client) DeleteUser(ctx context.Context, id int, reassignTo string) (*api.DeleteUserResponse, error) {
+	query := url.Values{}
+	if reassignTo != "" {
+		query.Set("reassign_to", reassignTo)
+	}
+	var resp api.DeleteUserResponse
+	if err := c.do(ctx, http.MethodDelete, "/admin/users/"+strconv.Itoa(id), query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
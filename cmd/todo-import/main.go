@@ -0,0 +1,185 @@
+// Command todo-import uploads a CSV, JSON, Todoist or Trello export to a
+// running Team To-Do server through POST /todos/import.
+//
+//	todo-import -user admin -password admin -dry-run backlog.csv
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type importError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// The server's error body (application/problem+json)
+type problemDetail struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+	Errors []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type importResult struct {
+	DryRun   bool          `json:"dry_run"`
+	Format   string        `json:"format"`
+	Total    int           `json:"total"`
+	Imported int           `json:"imported"`
+	Errors   []importError `json:"errors"`
+	Todos    []struct {
+		Text string `json:"text"`
+		User string `json:"user"`
+	} `json:"todos"`
+}
+
+func main() {
+	server := flag.String("server", envOr("TODO_SERVER", "http://localhost:8080"), "server URL")
+	username := flag.String("user", os.Getenv("TODO_USERNAME"), "username to log in as")
+	password := flag.String("password", os.Getenv("TODO_PASSWORD"), "password")
+	code := flag.String("code", "", "two-factor code, if the account needs one")
+	format := flag.String("format", "", "csv, json, todoist or trello (detected when empty)")
+	dryRun := flag.Bool("dry-run", false, "validate and report without importing")
+	defaultUser := flag.String("default-user", "", "assignee for rows without one (defaults to you)")
+	mapping := flag.String("map", "", "CSV columns to use, e.g. text=Title,user=Owner")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: todo-import [flags] FILE\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *username == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	base := strings.TrimRight(*server, "/")
+
+	if err := login(client, base, *username, *password, *code); err != nil {
+		fail(err)
+	}
+
+	query := url.Values{}
+	if *format != "" {
+		query.Set("format", *format)
+	}
+	if *dryRun {
+		query.Set("dry_run", "true")
+	}
+	if *defaultUser != "" {
+		query.Set("default_user", *defaultUser)
+	}
+	for _, pair := range strings.Split(*mapping, ",") {
+		if field, column, ok := strings.Cut(pair, "="); ok {
+			query.Set("map_"+strings.TrimSpace(field), strings.TrimSpace(column))
+		}
+	}
+
+	resp, err := client.Post(base+"/api/v1/todos/import?"+query.Encode(), "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		fail(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 300 {
+		var problem problemDetail
+		if json.Unmarshal(body, &problem) != nil || problem.Detail == "" {
+			fail(fmt.Errorf("import failed (%s): %s", resp.Status, strings.TrimSpace(string(body))))
+		}
+		for _, e := range problem.Errors {
+			fmt.Printf("%s: %s\n", e.Field, e.Message)
+		}
+		fail(errors.New(problem.Detail))
+	}
+
+	var result importResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		fail(fmt.Errorf("unexpected response: %v", err))
+	}
+
+	if result.DryRun {
+		for _, e := range result.Errors {
+			if e.Field != "" {
+				fmt.Printf("row %d: %s: %s\n", e.Row, e.Field, e.Error)
+			} else {
+				fmt.Printf("row %d: %s\n", e.Row, e.Error)
+			}
+		}
+		fmt.Printf("Dry run (%s): %d rows, %d valid, %d errors. Nothing was imported.\n",
+			result.Format, result.Total, len(result.Todos), len(result.Errors))
+		if len(result.Errors) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Printf("Imported %d todos (%s).\n", result.Imported, result.Format)
+}
+
+// Log in and keep the session cookie in the client's jar
+func login(client *http.Client, base, username, password, code string) error {
+	var reply struct {
+		TwoFactorRequired bool `json:"two_factor_required"`
+	}
+	if err := postJSON(client, base+"/api/v1/login", map[string]string{"username": username, "password": password}, &reply); err != nil {
+		return err
+	}
+	if !reply.TwoFactorRequired {
+		return nil
+	}
+	if code == "" {
+		return fmt.Errorf("%s has two-factor authentication enabled; pass -code", username)
+	}
+	return postJSON(client, base+"/api/v1/login/2fa", map[string]string{"code": code}, &reply)
+}
+
+func postJSON(client *http.Client, target string, payload, reply interface{}) error {
+	body, _ := json.Marshal(payload)
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		var problem problemDetail
+		if json.Unmarshal(data, &problem) == nil && problem.Detail != "" {
+			return fmt.Errorf("%s: %s", resp.Status, problem.Detail)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, reply)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "todo-import:", err)
+	os.Exit(1)
+}
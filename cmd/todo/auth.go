@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"to-do-list/api"
+	"to-do-list/client"
+)
+
+// todo login — Log in and save the session in the config file
+func runLogin(a *app, args []string) error {
+	fs := a.flags("login", "[-server URL] [-user NAME] [-code CODE]")
+	fs.StringVar(&a.server, "server", a.server, "server URL")
+	username := fs.String("user", os.Getenv("TODO_USERNAME"), "username (prompted when empty)")
+	code := fs.String("code", "", "two-factor or recovery code (prompted when needed)")
+	if _, err := a.parse(fs, args); err != nil {
+		return err
+	}
+
+	var err error
+	if *username == "" {
+		if *username, err = prompt("Username: "); err != nil {
+			return err
+		}
+	}
+	password := os.Getenv("TODO_PASSWORD")
+	if password == "" {
+		if password, err = readSecret("Password: "); err != nil {
+			return err
+		}
+	}
+
+	c, err := client.New(a.serverURL())
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	resp, err := c.Login(ctx, *username, password)
+	if errors.Is(err, client.ErrTwoFactorRequired) {
+		if *code == "" {
+			if *code, err = prompt("Authentication or recovery code: "); err != nil {
+				return err
+			}
+		}
+		req := api.TwoFactorLoginRequest{Code: *code}
+		if len(*code) > 6 {
+			req = api.TwoFactorLoginRequest{RecoveryCode: *code}
+		}
+		resp, err = c.LoginSecondFactor(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.config.Server = a.serverURL()
+	a.config.Username = resp.User.Username
+	a.config.Token = c.Token()
+	if err := saveConfig(a.configPath, a.config); err != nil {
+		return fmt.Errorf("saving session: %w", err)
+	}
+
+	if resp.TwoFactorSetupRequired {
+		fmt.Fprintln(os.Stderr, "Your account must set up two-factor authentication in the web app before using the API.")
+	}
+	return printResult(a.output, fmt.Sprintf("Logged in to %s as %s (%s)", a.config.Server, resp.User.Username, resp.User.Role), resp)
+}
+
+// todo logout — End the session on the server and forget it locally
+func runLogout(a *app, args []string) error {
+	fs := a.flags("logout", "")
+	if _, err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if a.config.Token != "" {
+		c, err := a.client()
+		if err != nil {
+			return err
+		}
+		// The local session is forgotten even if the server can't be reached
+		if err := c.Logout(context.Background()); err != nil {
+			fmt.Fprintln(os.Stderr, "todo: server logout failed:", err)
+		}
+	}
+	a.config.Token = ""
+	if err := saveConfig(a.configPath, a.config); err != nil {
+		return err
+	}
+	return printResult(a.output, "Logged out", nil)
+}
+
+// todo whoami — Show the user the saved session belongs to
+func runWhoami(a *app, args []string) error {
+	fs := a.flags("whoami", "")
+	if _, err := a.parse(fs, args); err != nil {
+		return err
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	me, err := c.Me(context.Background())
+	if err != nil {
+		return err
+	}
+	if a.output == outputJSON {
+		return printJSON(me)
+	}
+	fmt.Printf("%s (%s) on %s\n", me.Username, me.Role, a.serverURL())
+	fmt.Printf("Permissions: %s\n", strings.Join(me.Permissions, ", "))
+	return nil
+}
+
+func prompt(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading %s: %w", strings.TrimSuffix(label, ": "), err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// Prompt without echoing the input, on terminals where stty can turn echo off
+func readSecret(label string) (string, error) {
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	return prompt(label)
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
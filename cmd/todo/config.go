@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// What `todo login` remembers between runs. The session token is stored rather
+// than the password, so the file is as sensitive as a logged-in browser.
+type config struct {
+	Server   string `json:"server"`
+	Username string `json:"username,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// TODO_CONFIG, or todo/config.json in the user's config directory
+func configPath() (string, error) {
+	if path := os.Getenv("TODO_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "todo", "config.json"), nil
+}
+
+// Load the config file; a missing file is an empty config
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Write the config readable by the owner only
+func saveConfig(path string, cfg *config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
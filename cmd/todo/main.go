@@ -0,0 +1,194 @@
+// Command todo manages todos on a Team To-Do server from the terminal.
+//
+//	todo login -server http://localhost:8080 -user alice
+//	todo ls -pending
+//	todo add "Write the release notes" -due 2024-07-01 -tag docs
+//	todo done 12
+//	todo -o json users
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"to-do-list/client"
+)
+
+const defaultServer = "http://localhost:8080"
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(a *app, args []string) error
+}
+
+var commands = []command{
+	{"login", "[-server URL] [-user NAME]", "Log in and save the session", runLogin},
+	{"logout", "", "End the session and forget it", runLogout},
+	{"whoami", "", "Show the logged-in user", runWhoami},
+	{"ls", "[-user NAME] [-pending | -done] [-tag TAG]", "List todos", runList},
+	{"add", "TEXT [-user NAME] [-due YYYY-MM-DD] [-tag TAG]...", "Add a todo", runAdd},
+	{"done", "ID...", "Mark todos as completed", runDone},
+	{"rm", "ID...", "Move todos to the trash", runRemove},
+	{"restore", "ID...", "Restore todos from the trash", runRestore},
+	{"users", "[ls | add | edit | rm] ...", "Manage users (run `todo users -h`)", runUsers},
+}
+
+// State shared by the subcommands
+type app struct {
+	configPath string
+	config     *config
+	server     string // -server or TODO_SERVER, overriding the saved server
+	output     string
+}
+
+// Stdin is shared so prompts after the first don't lose buffered input
+var stdin = bufio.NewReader(os.Stdin)
+
+func main() {
+	a := &app{}
+	global := flag.NewFlagSet("todo", flag.ContinueOnError)
+	global.StringVar(&a.server, "server", os.Getenv("TODO_SERVER"), "server URL, overriding the one saved by login")
+	global.StringVar(&a.output, "o", outputTable, "output format: table or json")
+	global.Usage = usage
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if global.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == global.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "todo: unknown command %q\n\n", global.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if a.configPath, err = configPath(); err == nil {
+		a.config, err = loadConfig(a.configPath)
+	}
+	if err == nil {
+		err = cmd.run(a, global.Args()[1:])
+	}
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "todo:", err)
+		if errors.Is(err, client.ErrUnauthorized) && cmd.name != "login" {
+			fmt.Fprintln(os.Stderr, "Run `todo login` to start a new session.")
+		}
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: todo [-server URL] [-o table|json] COMMAND [ARGS]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nThe session is saved in %s (set TODO_CONFIG to change).\n", displayConfigPath())
+}
+
+func displayConfigPath() string {
+	path, err := configPath()
+	if err != nil {
+		return "the user config directory"
+	}
+	return path
+}
+
+// Returned after a usage message has been printed
+var errUsage = errors.New("usage")
+
+// A flag set for a subcommand. Every subcommand also accepts -o.
+func (a *app) flags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet("todo "+name, flag.ContinueOnError)
+	fs.StringVar(&a.output, "o", a.output, "output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: todo %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parse flags wherever they appear among the arguments, so that
+// `todo add "text" -user bob` works, and return the positional arguments
+func (a *app) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if a.output != outputTable && a.output != outputJSON {
+		fmt.Fprintf(os.Stderr, "todo: -o must be table or json\n")
+		return nil, errUsage
+	}
+	return positional, nil
+}
+
+func (a *app) serverURL() string {
+	switch {
+	case a.server != "":
+		return a.server
+	case a.config.Server != "":
+		return a.config.Server
+	}
+	return defaultServer
+}
+
+// A client using the saved session
+func (a *app) client() (*client.Client, error) {
+	return client.New(a.serverURL(), client.WithToken(a.config.Token))
+}
+
+// Parse todo or user IDs from the arguments
+func parseIDs(fs *flag.FlagSet, args []string) ([]int, error) {
+	if len(args) == 0 {
+		fs.Usage()
+		return nil, errUsage
+	}
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an ID", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// Collects a repeatable string flag, e.g. -tag a -tag b
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
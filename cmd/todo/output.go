@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"to-do-list/api"
+)
+
+// Output modes for -o
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func printJSON(value interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
+
+func printTable(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func printTodos(output string, todos []api.Todo) error {
+	if output == outputJSON {
+		if todos == nil {
+			todos = []api.Todo{}
+		}
+		return printJSON(todos)
+	}
+	rows := make([][]string, 0, len(todos))
+	for _, t := range todos {
+		done := " "
+		if t.Completed {
+			done = "x"
+		}
+		tags := make([]string, len(t.Tags))
+		for i, tag := range t.Tags {
+			tags[i] = "#" + tag
+		}
+		rows = append(rows, []string{strconv.Itoa(t.ID), "[" + done + "]", t.User, t.DueDate, t.Text, strings.Join(tags, " ")})
+	}
+	return printTable([]string{"ID", "DONE", "USER", "DUE", "TEXT", "TAGS"}, rows)
+}
+
+func printUsers(output string, users []api.User) error {
+	if output == outputJSON {
+		if users == nil {
+			users = []api.User{}
+		}
+		return printJSON(users)
+	}
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		twoFactor := "off"
+		switch {
+		case u.TOTPEnabled:
+			twoFactor = "on"
+		case u.TOTPRequired:
+			twoFactor = "required"
+		}
+		rows = append(rows, []string{strconv.Itoa(u.ID), u.Username, u.DisplayName, u.Role, u.Email, twoFactor})
+	}
+	return printTable([]string{"ID", "USERNAME", "NAME", "ROLE", "EMAIL", "2FA"}, rows)
+}
+
+// Print a confirmation in table mode, or the affected object in JSON mode
+func printResult(output, message string, value interface{}) error {
+	if output == outputJSON {
+		if value == nil {
+			value = api.Message{Message: message}
+		}
+		return printJSON(value)
+	}
+	fmt.Println(message)
+	return nil
+}
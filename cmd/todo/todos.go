@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"to-do-list/api"
+	"to-do-list/client"
+)
+
+// todo ls — List todos, optionally filtered
+func runList(a *app, args []string) error {
+	fs := a.flags("ls", "[-user NAME] [-pending | -done] [-tag TAG]")
+	user := fs.String("user", "", "only todos assigned to this user")
+	pending := fs.Bool("pending", false, "only open todos")
+	done := fs.Bool("done", false, "only completed todos")
+	tag := fs.String("tag", "", "only todos with this tag")
+	if _, err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if *pending && *done {
+		fmt.Fprintln(os.Stderr, "todo: -pending and -done can't be combined")
+		return errUsage
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	todos, err := c.ListTodos(context.Background(), client.TodoFilter{User: *user})
+	if err != nil {
+		return err
+	}
+
+	var shown []api.Todo
+	wantTag := strings.ToLower(strings.TrimPrefix(*tag, "#"))
+	for _, t := range todos {
+		if (*pending && t.Completed) || (*done && !t.Completed) {
+			continue
+		}
+		if wantTag != "" && !hasTag(t, wantTag) {
+			continue
+		}
+		shown = append(shown, t)
+	}
+	sort.Slice(shown, func(i, j int) bool { return shown[i].ID < shown[j].ID })
+	return printTodos(a.output, shown)
+}
+
+func hasTag(t api.Todo, tag string) bool {
+	for _, have := range t.Tags {
+		if have == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// todo add — Create a todo
+func runAdd(a *app, args []string) error {
+	fs := a.flags("add", "TEXT [-user NAME] [-due YYYY-MM-DD] [-tag TAG]...")
+	user := fs.String("user", "", "assignee (defaults to you)")
+	due := fs.String("due", "", "due date, YYYY-MM-DD")
+	var tags stringList
+	fs.Var(&tags, "tag", "tag, repeatable")
+	text, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(text) == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	todo, err := c.CreateTodo(context.Background(), api.NewTodo{
+		Text:    strings.Join(text, " "),
+		User:    *user,
+		DueDate: *due,
+		Tags:    tags,
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(a.output, fmt.Sprintf("Added todo %d for %s", todo.ID, todo.User), todo)
+}
+
+// todo done — Complete todos
+func runDone(a *app, args []string) error {
+	return eachTodo(a, "done", args, "Completed", func(c *client.Client, id int) error {
+		return c.CompleteTodo(context.Background(), id)
+	})
+}
+
+// todo rm — Move todos to the trash
+func runRemove(a *app, args []string) error {
+	return eachTodo(a, "rm", args, "Trashed", func(c *client.Client, id int) error {
+		return c.DeleteTodo(context.Background(), id)
+	})
+}
+
+// todo restore — Bring todos back from the trash
+func runRestore(a *app, args []string) error {
+	return eachTodo(a, "restore", args, "Restored", func(c *client.Client, id int) error {
+		_, err := c.RestoreTodo(context.Background(), id)
+		return err
+	})
+}
+
+// Apply action to every ID given, reporting each failure and carrying on
+func eachTodo(a *app, name string, args []string, verb string, action func(*client.Client, int) error) error {
+	fs := a.flags(name, "ID...")
+	positional, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	ids, err := parseIDs(fs, positional)
+	if err != nil {
+		return err
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+
+	var succeeded []int
+	var failed int
+	for _, id := range ids {
+		if err := action(c, id); err != nil {
+			if len(ids) == 1 {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "todo: %d: %v\n", id, err)
+			failed++
+			continue
+		}
+		succeeded = append(succeeded, id)
+	}
+
+	if a.output == outputJSON {
+		if succeeded == nil {
+			succeeded = []int{}
+		}
+		printJSON(map[string][]int{"ids": succeeded})
+	} else if len(succeeded) > 0 {
+		strs := make([]string, len(succeeded))
+		for i, id := range succeeded {
+			strs[i] = fmt.Sprint(id)
+		}
+		fmt.Println(verb, "todo", strings.Join(strs, ", "))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d todos failed", failed, len(ids))
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"to-do-list/api"
+	"to-do-list/client"
+)
+
+var userCommands = []command{
+	{"ls", "[-o table|json]", "List users", runUsersList},
+	{"add", "USERNAME [-name DISPLAY_NAME] [-role ROLE] [-email EMAIL] [-avatar URL] [-password PASSWORD]", "Create a user", runUsersAdd},
+	{"edit", "ID [-username NAME] [-name DISPLAY_NAME] [-role ROLE] [-email EMAIL] [-avatar URL] [-password PASSWORD]", "Change a user", runUsersEdit},
+	{"rm", "ID [-reassign-to NAME]", "Move a user and their todos to the trash", runUsersRemove},
+}
+
+// todo users — Dispatch to a user management subcommand, listing by default
+func runUsers(a *app, args []string) error {
+	help := len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help")
+	if !help && (len(args) == 0 || strings.HasPrefix(args[0], "-")) {
+		return runUsersList(a, args)
+	}
+	if !help {
+		for _, cmd := range userCommands {
+			if cmd.name == args[0] {
+				return cmd.run(a, args[1:])
+			}
+		}
+		fmt.Fprintf(os.Stderr, "todo: unknown users command %q\n\n", args[0])
+	}
+	fmt.Fprintf(os.Stderr, "Usage: todo users COMMAND\n\nCommands:\n")
+	for _, cmd := range userCommands {
+		fmt.Fprintf(os.Stderr, "  %-5s %s\n        %s\n", cmd.name, cmd.summary, cmd.args)
+	}
+	return errUsage
+}
+
+func runUsersList(a *app, args []string) error {
+	fs := a.flags("users ls", "")
+	if _, err := a.parse(fs, args); err != nil {
+		return err
+	}
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	users, err := c.ListUsers(context.Background())
+	if err != nil {
+		return err
+	}
+	return printUsers(a.output, users)
+}
+
+func runUsersAdd(a *app, args []string) error {
+	fs := a.flags("users add", "USERNAME [-name DISPLAY_NAME] [-role ROLE] [-email EMAIL] [-avatar URL] [-password PASSWORD]")
+	displayName := fs.String("name", "", "full name to display")
+	role := fs.String("role", "", "role (defaults to user)")
+	email := fs.String("email", "", "email address")
+	avatarURL := fs.String("avatar", "", "avatar image URL")
+	password := fs.String("password", "", "password (prompted when empty)")
+	positional, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	if *password == "" {
+		if *password, err = readSecret("Password for " + positional[0] + ": "); err != nil {
+			return err
+		}
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	user, err := c.CreateUser(context.Background(), api.CreateUserRequest{
+		Username:    positional[0],
+		Password:    *password,
+		Role:        *role,
+		DisplayName: *displayName,
+		Email:       *email,
+		AvatarURL:   *avatarURL,
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(a.output, fmt.Sprintf("Created user %d: %s (%s)", user.ID, user.Username, user.Role), user)
+}
+
+func runUsersEdit(a *app, args []string) error {
+	fs := a.flags("users edit", "ID [-username NAME] [-name DISPLAY_NAME] [-role ROLE] [-email EMAIL] [-avatar URL] [-password PASSWORD]")
+	username := fs.String("username", "", "new username")
+	displayName := fs.String("name", "", "new display name, \"\" to clear")
+	role := fs.String("role", "", "new role")
+	email := fs.String("email", "", "new email address, \"\" to clear")
+	avatarURL := fs.String("avatar", "", "new avatar image URL, \"\" to clear")
+	password := fs.String("password", "", "new password")
+	positional, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	ids, err := parseIDs(fs, positional)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	// The server replaces username and role together, so start from the current values
+	current, err := findUser(ctx, c, ids[0])
+	if err != nil {
+		return err
+	}
+	update := api.UpdateUserRequest{Username: current.Username, Role: current.Role, Password: *password}
+	if *username != "" {
+		update.Username = *username
+	}
+	if *role != "" {
+		update.Role = *role
+	}
+	// Profile fields are only sent when given, so the others stay as they are
+	if set["name"] {
+		update.DisplayName = displayName
+	}
+	if set["email"] {
+		update.Email = email
+	}
+	if set["avatar"] {
+		update.AvatarURL = avatarURL
+	}
+	if err := c.UpdateUser(ctx, ids[0], update); err != nil {
+		return err
+	}
+	return printResult(a.output, fmt.Sprintf("Updated user %d: %s (%s)", ids[0], update.Username, update.Role), nil)
+}
+
+func runUsersRemove(a *app, args []string) error {
+	fs := a.flags("users rm", "ID [-reassign-to NAME]")
+	reassignTo := fs.String("reassign-to", "", "give the user's todos to this user instead of trashing them")
+	positional, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	ids, err := parseIDs(fs, positional)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	c, err := a.client()
+	if err != nil {
+		return err
+	}
+	resp, err := c.DeleteUser(context.Background(), ids[0], *reassignTo)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Moved user %d to the trash with %d todos", ids[0], resp.TrashedTodos)
+	if resp.ReassignedTo != "" {
+		message = fmt.Sprintf("Moved user %d to the trash; %d todos reassigned to %s", ids[0], resp.ReassignedTodos, resp.ReassignedTo)
+	}
+	return printResult(a.output, message, resp)
+}
+
+func findUser(ctx context.Context, c *client.Client, id int) (*api.User, error) {
+	users, err := c.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if users[i].ID == id {
+			return &users[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no user with ID %d", id)
+}
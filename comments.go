@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"to-do-list/api"
+)
+
+const maxCommentLength = 1000
+
+// Comments refer to their author by user ID, like todos. User is filled in
+// with the author's current username when a comment is returned.
+type Comment struct {
+	ID        int    `json:"id"`
+	TodoID    int    `json:"todo_id"`
+	UserID    int    `json:"user_id"`
+	User      string `json:"user"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"created_at"`
+}
+
+var comments []Comment
+var nextCommentID = 1
+
+// Remove the comments on todos that have been purged, by todo ID. Must be
+// called with dataMu held.
+func purgeTodoComments(purged map[int]bool) {
+	if len(purged) == 0 {
+		return
+	}
+	var remaining []Comment
+	for _, c := range comments {
+		if !purged[c.TodoID] {
+			remaining = append(remaining, c)
+		}
+	}
+	comments = remaining
+}
+
+// Remove the comments a purged user wrote. Must be called with dataMu held.
+func purgeUserComments(userID int) {
+	var remaining []Comment
+	for _, c := range comments {
+		if c.UserID != userID {
+			remaining = append(remaining, c)
+		}
+	}
+	comments = remaining
+}
+
+// A comment as returned, with its author's current username. Authors in the
+// trash keep their name. Must be called with dataMu held.
+func commentWithAuthor(c Comment) Comment {
+	if user := findAnyUserByID(c.UserID); user != nil {
+		c.User = user.Username
+	}
+	return c
+}
+
+// Find an active todo the caller is allowed to read, writing the error response if there is none.
+// Must be called with dataMu held.
+func readableTodo(w http.ResponseWriter, r *http.Request) (*Todo, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid ID")
+		return nil, false
+	}
+	for i, todo := range todos {
+		if todo.ID == id && todo.DeletedAt == "" {
+			if !canActOnTodo(r, todo.UserID, PermTodosReadOwn, PermTodosReadAny) {
+				writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden - cannot read this todo")
+				return nil, false
+			}
+			return &todos[i], true
+		}
+	}
+	writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Todo not found")
+	return nil, false
+}
+
+// GET /todos/{id}/comments — List a todo's comments, oldest first
+func getComments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	dataMu.RLock()
+	todo, ok := readableTodo(w, r)
+	list := []Comment{}
+	if ok {
+		for _, c := range comments {
+			if c.TodoID == todo.ID {
+				list = append(list, commentWithAuthor(c))
+			}
+		}
+	}
+	dataMu.RUnlock()
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// POST /todos/{id}/comments — Comment on a todo the caller can read
+func addComment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req struct {
+		Text string `json:"text"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if msg := commentRule.validate(&req.Text); msg != "" {
+		writeFieldError(w, r, "text", msg)
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	todo, ok := readableTodo(w, r)
+	if !ok {
+		return
+	}
+
+	author := r.Header.Get("X-User-Name")
+	comment := Comment{
+		ID:        nextCommentID,
+		TodoID:    todo.ID,
+		UserID:    callerID(r),
+		Text:      req.Text,
+		CreatedAt: time.Now().Format(timestampFormat),
+	}
+	nextCommentID++
+	comments = append(comments, comment)
+
+	// Tell the assignee and whoever created the todo
+	data := notificationData{Todo: *todo, Comment: comment.Text}
+	notifyUser(todo.UserID, NotifyComment, author, data)
+	if todo.CreatedByID != todo.UserID {
+		notifyUser(todo.CreatedByID, NotifyComment, author, data)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(commentWithAuthor(comment))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"to-do-list/api"
+)
+
+// A todo for alice, one for bob and one in the trash. charlie gets a role that
+// can only read their own todos.
+func useCommentTodos(t *testing.T) http.Handler {
+	t.Helper()
+	useTestData(t)
+	useDefaultRoles(t)
+	useRateLimits(t, map[string]RateLimit{})
+	roles["viewer"] = &Role{Name: "viewer", Permissions: []string{PermTodosReadOwn}}
+	users[3].Role = "viewer"
+	todos = []Todo{
+		{ID: 1, Text: "Alice's", UserID: 2, User: "alice", CreatedByID: 2},
+		{ID: 2, Text: "Bob's", UserID: 3, User: "bob", CreatedByID: 3},
+		{ID: 3, Text: "Trashed", UserID: 2, User: "alice", CreatedByID: 2, DeletedAt: time.Now().Format(timestampFormat)},
+	}
+	nextID = 4
+	return newRouter()
+}
+
+func listComments(t *testing.T, handler http.Handler, cookies []*http.Cookie, todoID string) []Comment {
+	t.Helper()
+	rec := doJSON(t, handler, http.MethodGet, "/api/v1/todos/"+todoID+"/comments", "", cookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list comments: status %d: %s", rec.Code, rec.Body)
+	}
+	var list []Comment
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// The "author: text" of each comment
+func commentLines(list []Comment) []string {
+	var lines []string
+	for _, c := range list {
+		lines = append(lines, c.User+": "+c.Text)
+	}
+	return lines
+}
+
+func TestCommentsShowTheAuthorsCurrentUsername(t *testing.T) {
+	router := useCommentTodos(t)
+	alice := logIn(t, router, "alice", "password123")
+	bob := logIn(t, router, "bob", "password123")
+
+	rec := doJSON(t, router, http.MethodPost, "/api/v1/todos/1/comments", `{"text":"  Started on this  "}`, alice)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("comment: status %d: %s", rec.Code, rec.Body)
+	}
+	var created Comment
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.ID != 1 || created.TodoID != 1 || created.UserID != 2 || created.User != "alice" || created.Text != "Started on this" {
+		t.Errorf("created %+v", created)
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/todos/1/comments", `{"text":"Need a hand?"}`, bob); rec.Code != http.StatusCreated {
+		t.Fatalf("comment: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// Only the author's ID is stored, so a rename shows up on their comments
+	dataMu.Lock()
+	users[1].Username = "alicia"
+	dataMu.Unlock()
+	if got, want := commentLines(listComments(t, router, bob, "1")), []string{"alicia: Started on this", "bob: Need a hand?"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("comments %q, want %q", got, want)
+	}
+	if got := listComments(t, router, bob, "2"); len(got) != 0 {
+		t.Errorf("comments on todo 2 %+v, want none", got)
+	}
+}
+
+func TestCommentsNeedATodoTheCallerCanRead(t *testing.T) {
+	router := useCommentTodos(t)
+	charlie := logIn(t, router, "charlie", "password123")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{"list someone else's", http.MethodGet, "/api/v1/todos/1/comments", "", http.StatusForbidden, api.CodeForbidden},
+		{"comment on someone else's", http.MethodPost, "/api/v1/todos/1/comments", `{"text":"Hi"}`, http.StatusForbidden, api.CodeForbidden},
+		{"trashed", http.MethodGet, "/api/v1/todos/3/comments", "", http.StatusNotFound, api.CodeNotFound},
+		{"missing", http.MethodPost, "/api/v1/todos/99/comments", `{"text":"Hi"}`, http.StatusNotFound, api.CodeNotFound},
+		{"bad ID", http.MethodGet, "/api/v1/todos/abc/comments", "", http.StatusBadRequest, api.CodeInvalidID},
+		{"blank text", http.MethodPost, "/api/v1/todos/1/comments", `{"text":"   "}`, http.StatusBadRequest, api.CodeValidationFailed},
+		{"text of the wrong type", http.MethodPost, "/api/v1/todos/1/comments", `{"text":5}`, http.StatusBadRequest, api.CodeValidationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doJSON(t, router, tt.method, tt.target, tt.body, charlie)
+			var problem api.Problem
+			json.Unmarshal(rec.Body.Bytes(), &problem)
+			if rec.Code != tt.status || problem.Code != tt.code {
+				t.Errorf("status %d, code %q; want %d, %q", rec.Code, problem.Code, tt.status, tt.code)
+			}
+		})
+	}
+	if len(comments) != 0 {
+		t.Errorf("comments saved by rejected requests: %+v", comments)
+	}
+}
+
+func TestPurgedUsersLoseTheirComments(t *testing.T) {
+	router := useCommentTodos(t)
+	alice := logIn(t, router, "alice", "password123")
+	bob := logIn(t, router, "bob", "password123")
+	for _, c := range []struct {
+		cookies []*http.Cookie
+		todo    string
+		text    string
+	}{
+		{alice, "1", "Mine"},
+		{bob, "1", "Bob on alice's"},
+		{alice, "2", "Alice on bob's"},
+		{bob, "2", "Bob's own"},
+	} {
+		if rec := doJSON(t, router, http.MethodPost, "/api/v1/todos/"+c.todo+"/comments", `{"text":"`+c.text+`"}`, c.cookies); rec.Code != http.StatusCreated {
+			t.Fatalf("comment: status %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	dataMu.Lock()
+	purgeUserRecord(2)
+	dataMu.Unlock()
+
+	// Alice's todo goes with all its comments, and her comment on bob's todo with her
+	if got, want := commentLines(listComments(t, router, bob, "2")), []string{"bob: Bob's own"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("comments on bob's todo %q, want %q", got, want)
+	}
+	if len(comments) != 1 {
+		t.Errorf("%d comments left, want 1: %+v", len(comments), comments)
+	}
+}
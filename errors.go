@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"to-do-list/api"
+)
+
+func newProblem(status int, code, detail string) *api.Problem {
+	return &api.Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// Write an error as application/problem+json, tagged with the request's ID and path
+func writeProblem(w http.ResponseWriter, r *http.Request, problem *api.Problem) {
+	writeProblemBody(w, r, problem, problem)
+}
+
+// Write a problem whose body carries members of its own next to the problem's.
+// The body must embed the problem.
+func writeProblemBody(w http.ResponseWriter, r *http.Request, problem *api.Problem, body interface{}) {
+	problem.Instance = r.URL.Path
+	problem.RequestID = r.Header.Get("X-Request-ID")
+
+	w.Header().Set("Content-Type", api.ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Del("Content-Disposition")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblem(w, r, newProblem(status, code, detail))
+}
+
+// Report a single invalid request field as a 400
+func writeFieldError(w http.ResponseWriter, r *http.Request, field, message string) {
+	writeFieldErrors(w, r, []api.FieldError{{Field: field, Code: api.CodeValidationFailed, Message: message}})
+}
+
+// Report every invalid request field in one 400
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, errs []api.FieldError) {
+	detail := errs[0].Message
+	if len(errs) > 1 {
+		detail = fmt.Sprintf("%d fields are invalid", len(errs))
+	}
+	problem := newProblem(http.StatusBadRequest, api.CodeValidationFailed, detail)
+	problem.Errors = errs
+	writeProblem(w, r, problem)
+}
+
+// Request IDs supplied by a client or proxy are kept if they look sane
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	return strings.IndexFunc(id, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c))
+	}) < 0
+}
+
+// Give every request an ID, echoed in the X-Request-ID response header, in error
+// bodies and in log lines written with the request's context
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = randomToken(12)
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// Fallback for paths no route matches
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, api.CodeNotFound, fmt.Sprintf("No such endpoint: %s", r.URL.Path))
+}
+
+// Fallback for known paths requested with the wrong method
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, fmt.Sprintf("%s is not supported on %s", r.Method, r.URL.Path))
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types broadcast to /events subscribers
+const (
+	EventTodoCreated = "todo.created"
+	EventTodoUpdated = "todo.updated"
+	EventTodoDeleted = "todo.deleted"
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+const (
+	eventHistorySize     = 1000 // Events kept for Last-Event-ID replay
+	subscriberBufferSize = 64
+)
+
+// Streams send a comment this often to keep proxies from closing them, and
+// check that their session is still valid
+var eventHeartbeat = 25 * time.Second
+
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+
+	ownerID int // User a todo event belongs to, used for visibility
+}
+
+// Report whether a user is allowed to see an event, using the same permissions as the REST API
+func (e Event) visibleTo(user *User) bool {
+	if user == nil {
+		return false
+	}
+	switch e.Type {
+	case EventTodoCreated, EventTodoUpdated, EventTodoDeleted:
+		if roleHasPermission(user.Role, PermTodosReadAny) {
+			return true
+		}
+		return e.ownerID == user.ID && roleHasPermission(user.Role, PermTodosReadOwn)
+	case EventUserCreated, EventUserUpdated, EventUserDeleted:
+		return roleHasPermission(user.Role, PermUsersRead)
+	}
+	return false
+}
+
+type subscriber struct {
+	userID    int
+	sessionID string
+	events    chan Event
+}
+
+// Hub fans events out to connected subscribers and keeps a short history so
+// clients can resume after a reconnect
+type Hub struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+var hub = NewHub()
+
+func NewHub() *Hub {
+	return &Hub{
+		nextID:      1,
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// Publish an event to every subscriber allowed to see it. Slow subscribers are
+// disconnected rather than blocking the handler; they resume via Last-Event-ID.
+// Must be called with dataMu held, as visibility depends on the users' roles.
+func (h *Hub) Publish(eventType string, ownerID int, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	event := Event{ID: h.nextID, Type: eventType, Data: data, ownerID: ownerID}
+	h.nextID++
+
+	h.history = append(h.history, event)
+	if len(h.history) > eventHistorySize {
+		h.history = h.history[len(h.history)-eventHistorySize:]
+	}
+
+	for sub := range h.subscribers {
+		if !event.visibleTo(findUserByID(context.Background(), sub.userID)) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribe a user's session, returning the missed events after lastID that they
+// may see. complete is false when lastID is older than the retained history.
+// Must be called with dataMu held.
+func (h *Hub) Subscribe(userID int, sessionID string, lastID uint64) (sub *subscriber, missed []Event, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub = &subscriber{userID: userID, sessionID: sessionID, events: make(chan Event, subscriberBufferSize)}
+	if h.closed {
+		close(sub.events)
+		return sub, nil, true
+	}
+	h.subscribers[sub] = struct{}{}
+
+	complete = true
+	if lastID > 0 {
+		// Events were dropped from the history, or the IDs restarted with the server
+		if (len(h.history) > 0 && h.history[0].ID > lastID+1) || lastID >= h.nextID {
+			complete = false
+		}
+		user := findUserByID(context.Background(), userID)
+		for _, event := range h.history {
+			if event.ID > lastID && event.visibleTo(user) {
+				missed = append(missed, event)
+			}
+		}
+	}
+	return sub, missed, complete
+}
+
+func (h *Hub) Unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// End the streams of a session that logged out
+func (h *Hub) CloseSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	h.disconnect(func(sub *subscriber) bool { return sub.sessionID == sessionID })
+}
+
+// End every stream of a user whose sessions were revoked
+func (h *Hub) CloseUser(userID int) {
+	h.disconnect(func(sub *subscriber) bool { return sub.userID == userID })
+}
+
+func (h *Hub) disconnect(match func(sub *subscriber) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		if match(sub) {
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// End every stream, and any opened later, so the server can shut down. Clients
+// reconnect to the next server and resume via Last-Event-ID.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Fields of a user that are safe to share with other users
+func publicUser(user User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           user.ID,
+		"username":     user.Username,
+		"display_name": user.DisplayName,
+		"role":         user.Role,
+		"avatar_url":   user.AvatarURL,
+	}
+}
+
+func publishTodo(eventType string, todo Todo) {
+	hub.Publish(eventType, todo.UserID, todo)
+}
+
+func publishUser(eventType string, user User) {
+	hub.Publish(eventType, 0, publicUser(user))
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// GET /events — Server-Sent Events stream of todo and user changes
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	rc := http.NewResponseController(w)
+	// The stream outlives any server write timeout
+	rc.SetWriteDeadline(time.Time{})
+
+	userID := callerID(r)
+	session, _ := store.Get(r, "todo-session")
+	sessionID, _ := session.Values["session_id"].(string)
+
+	// Browsers send Last-Event-ID on reconnect; other clients can use ?last_event_id=
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+	dataMu.RLock()
+	sub, missed, complete := hub.Subscribe(userID, sessionID, lastID)
+	dataMu.RUnlock()
+	defer hub.Unsubscribe(sub)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	// Too far behind to replay: tell the client to reload its state
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.events:
+			if !ok {
+				// Dropped for falling behind, or the session ended. The client
+				// reconnects and replays, or is turned away if logged out.
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			// Catches the user being deleted or their session going stale
+			dataMu.RLock()
+			user := findUserByID(context.Background(), userID)
+			current := user != nil && sessionIsCurrent(session, user)
+			dataMu.RUnlock()
+			if !current {
+				return
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// One Server-Sent Events frame
+type sseFrame struct {
+	id, event, data, retry string
+}
+
+type eventStream struct {
+	frames chan sseFrame
+}
+
+// Start a server with the demo users and a fresh hub. charlie gets a role that
+// can only read their own todos.
+func startEventServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	useTestData(t)
+	useDefaultRoles(t)
+	useRateLimits(t, map[string]RateLimit{})
+	roles["viewer"] = &Role{Name: "viewer", Permissions: []string{PermTodosReadOwn}}
+	users[3].Role = "viewer"
+
+	server := httptest.NewServer(newRouter())
+	t.Cleanup(server.Close)
+
+	// Ends the streams before the server waits for them to finish
+	saved := hub
+	hub = NewHub()
+	t.Cleanup(func() {
+		hub.Close()
+		hub = saved
+	})
+	return server
+}
+
+// Open /events and wait until the stream is subscribed
+func openEventStream(t *testing.T, server *httptest.Server, cookies []*http.Cookie, target, lastEventID string) *eventStream {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+target, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("events: status %d", resp.StatusCode)
+	}
+
+	stream := &eventStream{frames: make(chan sseFrame, 16)}
+	go func() {
+		defer close(stream.frames)
+		var frame sseFrame
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			name, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch name {
+			case "id":
+				frame.id = value
+			case "event":
+				frame.event = value
+			case "data":
+				frame.data = value
+			case "retry":
+				frame.retry = value
+			case "":
+				if frame != (sseFrame{}) {
+					stream.frames <- frame
+				}
+				frame = sseFrame{}
+			}
+		}
+	}()
+
+	// The retry frame is written once the subscription is in place
+	if frame := stream.next(t); frame.retry == "" {
+		t.Fatalf("first frame %+v, want the retry interval", frame)
+	}
+	return stream
+}
+
+func (s *eventStream) next(t *testing.T) sseFrame {
+	t.Helper()
+	select {
+	case frame, ok := <-s.frames:
+		if !ok {
+			t.Fatal("event stream ended")
+		}
+		return frame
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event within 2s")
+	}
+	return sseFrame{}
+}
+
+// The "id event" of the next n frames
+func (s *eventStream) nextEvents(t *testing.T, n int) []string {
+	t.Helper()
+	var got []string
+	for i := 0; i < n; i++ {
+		frame := s.next(t)
+		got = append(got, frame.id+" "+frame.event)
+	}
+	return got
+}
+
+func (s *eventStream) waitForEnd(t *testing.T) {
+	t.Helper()
+	select {
+	case frame, ok := <-s.frames:
+		if ok {
+			t.Fatalf("got %+v, want the stream to end", frame)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event stream still open after 2s")
+	}
+}
+
+// Publish events as the handlers do, with the data lock held
+func publishForTest(publish func()) {
+	dataMu.Lock()
+	defer dataMu.Unlock()
+	publish()
+}
+
+func TestEventsAreFilteredByRole(t *testing.T) {
+	server := startEventServer(t)
+	admin := openEventStream(t, server, logIn(t, server.Config.Handler, "admin", "admin"), "/api/v1/events", "")
+	alice := openEventStream(t, server, logIn(t, server.Config.Handler, "alice", "password123"), "/api/v1/events", "")
+	charlie := openEventStream(t, server, logIn(t, server.Config.Handler, "charlie", "password123"), "/api/v1/events", "")
+
+	publishForTest(func() {
+		publishTodo(EventTodoCreated, Todo{ID: 1, Text: "Charlie's", UserID: 4})
+		publishTodo(EventTodoCreated, Todo{ID: 2, Text: "Alice's", UserID: 2})
+		publishUser(EventUserUpdated, users[2])
+		publishTodo(EventTodoDeleted, Todo{ID: 1, Text: "Charlie's", UserID: 4})
+	})
+
+	all := []string{"1 todo.created", "2 todo.created", "3 user.updated", "4 todo.deleted"}
+	if got := admin.nextEvents(t, 4); !reflect.DeepEqual(got, all) {
+		t.Errorf("admin got %v, want %v", got, all)
+	}
+	// The user role reads every todo and the team
+	if got := alice.nextEvents(t, 4); !reflect.DeepEqual(got, all) {
+		t.Errorf("alice got %v, want %v", got, all)
+	}
+	// todos:read:own only: their own todos and no users
+	if got, want := charlie.nextEvents(t, 2), []string{"1 todo.created", "4 todo.deleted"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("charlie got %v, want %v", got, want)
+	}
+}
+
+func TestEventsReplayAfterLastEventID(t *testing.T) {
+	server := startEventServer(t)
+	publishForTest(func() {
+		publishTodo(EventTodoCreated, Todo{ID: 1, Text: "Charlie's", UserID: 4})
+		publishTodo(EventTodoCreated, Todo{ID: 2, Text: "Alice's", UserID: 2})
+		publishUser(EventUserUpdated, users[2])
+		publishTodo(EventTodoUpdated, Todo{ID: 1, Text: "Charlie's", UserID: 4})
+	})
+
+	admin := openEventStream(t, server, logIn(t, server.Config.Handler, "admin", "admin"), "/api/v1/events?last_event_id=2", "")
+	if got, want := admin.nextEvents(t, 2), []string{"3 user.updated", "4 todo.updated"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("admin replay %v, want %v", got, want)
+	}
+
+	// The replay is filtered like live events
+	charlie := openEventStream(t, server, logIn(t, server.Config.Handler, "charlie", "password123"), "/api/v1/events", "1")
+	if got, want := charlie.nextEvents(t, 1), []string{"4 todo.updated"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("charlie replay %v, want %v", got, want)
+	}
+	publishForTest(func() { publishTodo(EventTodoDeleted, Todo{ID: 1, Text: "Charlie's", UserID: 4}) })
+	if got, want := charlie.nextEvents(t, 1), []string{"5 todo.deleted"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("charlie got %v after the replay, want %v", got, want)
+	}
+}
+
+func TestEventsResetWhenLastEventIDIsGone(t *testing.T) {
+	server := startEventServer(t)
+	publishForTest(func() {
+		for i := 0; i < eventHistorySize+2; i++ {
+			publishTodo(EventTodoUpdated, Todo{ID: 1, UserID: 2})
+		}
+	})
+	cookies := logIn(t, server.Config.Handler, "admin", "admin")
+
+	tests := []struct {
+		name        string
+		lastEventID string
+	}{
+		{"dropped from the history", "1"},
+		{"from before a restart", "5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := openEventStream(t, server, cookies, "/api/v1/events", tt.lastEventID)
+			if frame := stream.next(t); frame.event != "reset" {
+				t.Errorf("first frame %+v, want a reset event", frame)
+			}
+		})
+	}
+
+	// Replaying from the oldest event kept is complete
+	stream := openEventStream(t, server, cookies, "/api/v1/events", "2")
+	if frame := stream.next(t); frame.id != "3" {
+		t.Errorf("first frame %+v, want event 3", frame)
+	}
+}
+
+func TestEventStreamEndsOnLogout(t *testing.T) {
+	server := startEventServer(t)
+	first := logIn(t, server.Config.Handler, "alice", "password123")
+	second := logIn(t, server.Config.Handler, "alice", "password123")
+	loggedOut := openEventStream(t, server, first, "/api/v1/events", "")
+	other := openEventStream(t, server, second, "/api/v1/events", "")
+
+	if rec := doJSON(t, server.Config.Handler, http.MethodPost, "/api/v1/logout", "", first); rec.Code != http.StatusOK {
+		t.Fatalf("logout: status %d", rec.Code)
+	}
+	loggedOut.waitForEnd(t)
+
+	// Alice's other session keeps streaming
+	publishForTest(func() { publishTodo(EventTodoCreated, Todo{ID: 1, UserID: 2}) })
+	if got, want := other.nextEvents(t, 1), []string{"1 todo.created"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("other session got %v, want %v", got, want)
+	}
+}
+
+func TestEventStreamsEndWhenSessionsAreRevoked(t *testing.T) {
+	server := startEventServer(t)
+	alice := openEventStream(t, server, logIn(t, server.Config.Handler, "alice", "password123"), "/api/v1/events", "")
+	bob := openEventStream(t, server, logIn(t, server.Config.Handler, "bob", "password123"), "/api/v1/events", "")
+
+	publishForTest(func() { revokeSessions(findUserByID(context.Background(), 2)) })
+	alice.waitForEnd(t)
+
+	publishForTest(func() { publishTodo(EventTodoCreated, Todo{ID: 1, UserID: 3}) })
+	if got, want := bob.nextEvents(t, 1), []string{"1 todo.created"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bob got %v, want %v", got, want)
+	}
+}
+
+func TestEventStreamEndsAtTheHeartbeatAfterTheUserIsDeleted(t *testing.T) {
+	saved := eventHeartbeat
+	t.Cleanup(func() { eventHeartbeat = saved })
+	eventHeartbeat = 20 * time.Millisecond
+
+	server := startEventServer(t)
+	stream := openEventStream(t, server, logIn(t, server.Config.Handler, "alice", "password123"), "/api/v1/events", "")
+
+	publishForTest(func() { users[1].DeletedAt = time.Now().Format(timestampFormat) })
+	stream.waitForEnd(t)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats accepted in ?format=
+const (
+	ExportCSV    = "csv"
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+var exportContentTypes = map[string]string{
+	ExportCSV:    "text/csv; charset=utf-8",
+	ExportJSON:   "application/json",
+	ExportNDJSON: "application/x-ndjson",
+}
+
+// CSV columns, in the order they are written
+var (
+	todoExportColumns = []string{"id", "text", "user", "completed", "created_at", "created_by", "due_date"}
+	userExportColumns = []string{"id", "username", "role", "email", "totp_enabled", "totp_required", "sso", "display_name", "avatar_url"}
+)
+
+// The exported view of a user. Built field by field so passwords and secrets
+// can never end up in an export.
+type UserExport struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	Email        string `json:"email"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPRequired bool   `json:"totp_required"`
+	SSO          bool   `json:"sso"`
+	DisplayName  string `json:"display_name"`
+	AvatarURL    string `json:"avatar_url"`
+}
+
+func todoCSVRecord(todo Todo) []string {
+	return []string{
+		strconv.Itoa(todo.ID),
+		todo.Text,
+		todo.User,
+		strconv.FormatBool(todo.Completed),
+		todo.CreatedAt,
+		todo.CreatedBy,
+		todo.DueDate,
+	}
+}
+
+func userCSVRecord(user UserExport) []string {
+	return []string{
+		strconv.Itoa(user.ID),
+		user.Username,
+		user.Role,
+		user.Email,
+		strconv.FormatBool(user.TOTPEnabled),
+		strconv.FormatBool(user.TOTPRequired),
+		strconv.FormatBool(user.SSO),
+		user.DisplayName,
+		user.AvatarURL,
+	}
+}
+
+// Spreadsheets run cells starting with these characters as formulas
+const csvFormulaChars = "=+-@\t\r"
+
+// Prefix a cell a spreadsheet would run as a formula with "'", which makes it
+// plain text. Cells already starting with "'" get one more, so that import
+// can tell the added quote apart and strip it again (see csvUnescape).
+func csvSafe(field string) string {
+	if field != "" && strings.ContainsRune(csvFormulaChars+"'", rune(field[0])) {
+		return "'" + field
+	}
+	return field
+}
+
+// Undo csvSafe on an imported cell
+func csvUnescape(field string) string {
+	if len(field) > 1 && field[0] == '\'' && strings.ContainsRune(csvFormulaChars+"'", rune(field[1])) {
+		return field[1:]
+	}
+	return field
+}
+
+// Read ?format=, defaulting to CSV; writes the error response for unknown formats
+func exportFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportCSV
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		writeFieldError(w, r, "format", "Format must be csv, json or ndjson")
+		return "", false
+	}
+	return format, true
+}
+
+// Stream rows as CSV (RFC 4180: CRLF line endings, quoted where needed), a JSON
+// array or newline-delimited JSON. Each row is written as soon as it is encoded.
+func writeExport(w http.ResponseWriter, format, name string, columns []string, count int, row func(i int) ([]string, interface{})) {
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, name, time.Now().Format("20060102"), format))
+
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		cw.UseCRLF = true
+		cw.Write(columns)
+		for i := 0; i < count; i++ {
+			record, _ := row(i)
+			for j := range record {
+				record[j] = csvSafe(record[j])
+			}
+			if err := cw.Write(record); err != nil {
+				return
+			}
+		}
+		cw.Flush()
+
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		fmt.Fprint(w, "[")
+		for i := 0; i < count; i++ {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			_, value := row(i)
+			if err := enc.Encode(value); err != nil {
+				return
+			}
+		}
+		fmt.Fprint(w, "]\n")
+
+	case ExportNDJSON:
+		enc := json.NewEncoder(w)
+		for i := 0; i < count; i++ {
+			_, value := row(i)
+			if err := enc.Encode(value); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// GET /todos/export — Download the todos the caller can see, with the same filters as GET /todos
+func exportTodos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+	list, ok := visibleTodos(w, r)
+	if !ok {
+		return
+	}
+
+	writeExport(w, format, "todos", todoExportColumns, len(list), func(i int) ([]string, interface{}) {
+		return todoCSVRecord(list[i]), list[i]
+	})
+}
+
+// GET /admin/users/export — Download all active users, without passwords
+func exportUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	// Copy the users out so a slow download never holds the data lock
+	var list []UserExport
+	dataMu.RLock()
+	for _, user := range users {
+		if user.DeletedAt != "" {
+			continue
+		}
+		list = append(list, UserExport{
+			ID:           user.ID,
+			Username:     user.Username,
+			Role:         user.Role,
+			Email:        user.Email,
+			TOTPEnabled:  user.TOTPEnabled,
+			TOTPRequired: user.TOTPRequired,
+			SSO:          user.OIDCSubject != "",
+			DisplayName:  user.DisplayName,
+			AvatarURL:    user.AvatarURL,
+		})
+	}
+	dataMu.RUnlock()
+
+	writeExport(w, format, "users", userExportColumns, len(list), func(i int) ([]string, interface{}) {
+		return userCSVRecord(list[i]), list[i]
+	})
+}
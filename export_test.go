@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"testing"
+)
+
+// Cells a spreadsheet would run as formulas are exported as text, and an
+// exported CSV still imports back with every cell unchanged
+func TestCSVExportIsSafeAndImportsBackUnchanged(t *testing.T) {
+	texts := []string{"=SUM(A1:A3)", "+1 555 0100", "-5 degrees", "@alice, review this", "'quoted already", "''=twice", "it's plain", "plain, \"quoted\"\ntext"}
+	exported := []string{"'=SUM(A1:A3)", "'+1 555 0100", "'-5 degrees", "'@alice, review this", "''quoted already", "'''=twice", "it's plain", "plain, \"quoted\"\ntext"}
+
+	rec := httptest.NewRecorder()
+	writeExport(rec, ExportCSV, "todos", todoExportColumns, len(texts), func(i int) ([]string, interface{}) {
+		todo := Todo{ID: i + 1, Text: texts[i], User: "alice", DueDate: "2026-01-02"}
+		return todoCSVRecord(todo), todo
+	})
+	data := rec.Body.Bytes()
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, record := range records[1:] {
+		if record[1] != exported[i] {
+			t.Errorf("text %q exported as %q, want %q", texts[i], record[1], exported[i])
+		}
+	}
+
+	rows, err := parseCSVImport(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(texts) {
+		t.Fatalf("imported %d rows, want %d", len(rows), len(texts))
+	}
+	for i, row := range rows {
+		if row.Text != texts[i] || row.User != "alice" || row.DueDate != "2026-01-02" {
+			t.Errorf("row %d imported as %+v, want text %q", i, row, texts[i])
+		}
+	}
+}
@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/gorilla/sessions v1.2.2
+	golang.org/x/text v0.14.0
 )
 
 require github.com/gorilla/securecookie v1.1.2 // indirect
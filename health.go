@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"to-do-list/api"
+)
+
+// Set at build time with -ldflags "-X main.buildTime=2024-06-01T09:30:00Z"
+var buildTime string
+
+// Set once the sample data is linked and the roles are loaded. Read by /readyz
+// without touching the data lock, so probes never queue behind requests.
+var storeLoaded atomic.Bool
+
+// Probe endpoints are logged at debug level
+var probePaths = map[string]bool{"/healthz": true, "/readyz": true, "/version": true}
+
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type buildInfo struct {
+	Version    string `json:"version"`
+	Revision   string `json:"revision,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	Modified   bool   `json:"modified,omitempty"`
+	BuildTime  string `json:"build_time,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+// GET /healthz — Liveness: the process is up and serving requests
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// GET /readyz — Readiness: the store and session store can serve requests
+func readyz(w http.ResponseWriter, r *http.Request) {
+	result := readiness{Status: "ok", Checks: map[string]string{
+		"storage":  checkStorage(),
+		"sessions": checkSessionStore(),
+	}}
+	status := http.StatusOK
+	for _, check := range result.Checks {
+		if check != "ok" {
+			result.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// The store is in memory, so it is ready once it has been loaded and the roles
+// it depends on are there. A busy data lock only means requests are being
+// served, so it is not checked.
+func checkStorage() string {
+	if !storeLoaded.Load() {
+		return "store is not loaded"
+	}
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	if len(roles) == 0 {
+		return "roles are not loaded"
+	}
+	return "ok"
+}
+
+// Round-trip a value through the session cookie codecs
+func checkSessionStore() string {
+	if store == nil || len(store.Codecs) == 0 {
+		return "session store is not configured"
+	}
+	in := map[interface{}]interface{}{"probe": time.Now().Unix()}
+	encoded, err := store.Codecs[0].Encode("todo-session", in)
+	if err != nil {
+		return "encoding failed: " + err.Error()
+	}
+	out := make(map[interface{}]interface{})
+	if err := store.Codecs[0].Decode("todo-session", encoded, &out); err != nil {
+		return "decoding failed: " + err.Error()
+	}
+	if out["probe"] != in["probe"] {
+		return "decoded session does not match"
+	}
+	return "ok"
+}
+
+// GET /version — Module version, VCS revision and build time
+func version(w http.ResponseWriter, r *http.Request) {
+	info, err := readBuildInfo()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func readBuildInfo() (buildInfo, error) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return buildInfo{}, errors.New("build information is not available")
+	}
+	info := buildInfo{Version: bi.Main.Version, BuildTime: buildTime, GoVersion: bi.GoVersion}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.CommitTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info, nil
+}
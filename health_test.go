@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyzDoesNotWaitForTheDataLock(t *testing.T) {
+	saved := storeLoaded.Load()
+	t.Cleanup(func() { storeLoaded.Store(saved) })
+
+	probe := func() (int, readiness) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var result readiness
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Error(err)
+		}
+		return rec.Code, result
+	}
+
+	storeLoaded.Store(false)
+	if status, result := probe(); status != http.StatusServiceUnavailable || result.Checks["storage"] != "store is not loaded" {
+		t.Errorf("before loading: status %d, checks %v", status, result.Checks)
+	}
+
+	storeLoaded.Store(true)
+	dataMu.Lock()
+	defer dataMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if status, result := probe(); status != http.StatusOK || result.Status != "ok" {
+			t.Errorf("while a request holds the data lock: status %d, result %+v", status, result)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("/readyz waited for the data lock")
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"to-do-list/api"
+)
+
+// Import formats accepted in ?format=; left empty the format is detected from the body
+const (
+	ImportCSV     = "csv"
+	ImportJSON    = "json"
+	ImportTodoist = "todoist"
+	ImportTrello  = "trello"
+)
+
+const maxImportSize = 10 << 20
+
+// A todo read from an import file, before validation
+type importRow struct {
+	Text      string
+	User      string
+	Completed string
+	CreatedAt string
+	DueDate   string
+}
+
+type ImportError struct {
+	Row   int    `json:"row"` // 1-based record number, not counting a CSV header
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+type ImportResult struct {
+	DryRun   bool          `json:"dry_run"`
+	Format   string        `json:"format"`
+	Total    int           `json:"total"`
+	Imported int           `json:"imported"`
+	Errors   []ImportError `json:"errors"`
+	Todos    []Todo        `json:"todos"`
+}
+
+// CSV header names recognised for each field, compared case-insensitively
+var importColumnAliases = map[string][]string{
+	"text":       {"text", "content", "title", "name", "task", "todo"},
+	"user":       {"user", "username", "assignee", "owner", "responsible"},
+	"completed":  {"completed", "done", "checked", "status", "complete"},
+	"created_at": {"created_at", "created", "date_added", "added_at", "date"},
+	"due_date":   {"due_date", "due", "deadline"},
+}
+
+// Work out the format from the body when the caller didn't say
+func detectImportFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return ImportCSV
+	}
+	switch trimmed[0] {
+	case '[':
+		return ImportJSON
+	case '{':
+		var probe map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &probe) == nil {
+			if _, ok := probe["cards"]; ok {
+				return ImportTrello
+			}
+			if _, ok := probe["items"]; ok {
+				return ImportTodoist
+			}
+		}
+		return ImportJSON
+	}
+	return ImportCSV
+}
+
+// Parse CSV with a header row. mapping overrides which column feeds a field.
+func parseCSVImport(data []byte, mapping map[string]string) ([]importRow, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("CSV file is empty")
+	}
+
+	header := records[0]
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff") // Spreadsheet BOM
+	}
+	column := func(field string) int {
+		names := importColumnAliases[field]
+		if name, ok := mapping[field]; ok {
+			names = []string{name}
+		}
+		for _, name := range names {
+			for i, h := range header {
+				if strings.EqualFold(strings.TrimSpace(h), name) {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	textCol, userCol, completedCol, createdCol, dueCol := column("text"), column("user"), column("completed"), column("created_at"), column("due_date")
+	if textCol < 0 {
+		return nil, errors.New("CSV header has no text column; name one with map_text")
+	}
+
+	cell := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return csvUnescape(record[i])
+	}
+	var rows []importRow
+	for _, record := range records[1:] {
+		rows = append(rows, importRow{
+			Text:      cell(record, textCol),
+			User:      cell(record, userCol),
+			Completed: cell(record, completedCol),
+			CreatedAt: cell(record, createdCol),
+			DueDate:   cell(record, dueCol),
+		})
+	}
+	return rows, nil
+}
+
+// Parse our own JSON export: an array of todos
+func parseJSONImport(data []byte) ([]importRow, error) {
+	var list []Todo
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	rows := make([]importRow, 0, len(list))
+	for _, todo := range list {
+		rows = append(rows, importRow{
+			Text:      todo.Text,
+			User:      todo.User,
+			Completed: strconv.FormatBool(todo.Completed),
+			CreatedAt: todo.CreatedAt,
+			DueDate:   todo.DueDate,
+		})
+	}
+	return rows, nil
+}
+
+// Parse a Todoist sync export. Assignees are matched through the collaborator list.
+func parseTodoistImport(data []byte) ([]importRow, error) {
+	var export struct {
+		Items []struct {
+			Content        string          `json:"content"`
+			Checked        json.RawMessage `json:"checked"` // true/false or 0/1 depending on the API version
+			ResponsibleUID json.RawMessage `json:"responsible_uid"`
+			AddedAt        string          `json:"added_at"`
+			DateAdded      string          `json:"date_added"`
+			Due            *struct {
+				Date string `json:"date"`
+			} `json:"due"`
+		} `json:"items"`
+		Collaborators []struct {
+			ID       json.RawMessage `json:"id"`
+			Email    string          `json:"email"`
+			FullName string          `json:"full_name"`
+		} `json:"collaborators"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+
+	// IDs are numbers in older exports and strings in newer ones
+	collaborators := make(map[string]string)
+	for _, c := range export.Collaborators {
+		name := c.Email
+		if name == "" {
+			name = c.FullName
+		}
+		collaborators[strings.Trim(string(c.ID), `"`)] = name
+	}
+
+	rows := make([]importRow, 0, len(export.Items))
+	for _, item := range export.Items {
+		row := importRow{
+			Text:      item.Content,
+			Completed: strings.Trim(string(item.Checked), `"`),
+			CreatedAt: item.AddedAt,
+		}
+		if row.CreatedAt == "" {
+			row.CreatedAt = item.DateAdded
+		}
+		if uid := strings.Trim(string(item.ResponsibleUID), `"`); uid != "" && uid != "null" {
+			row.User = collaborators[uid]
+		}
+		if item.Due != nil {
+			row.DueDate = item.Due.Date
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Parse a Trello board export. Cards become todos assigned to their first member.
+func parseTrelloImport(data []byte) ([]importRow, error) {
+	var export struct {
+		Cards []struct {
+			ID          string   `json:"id"`
+			Name        string   `json:"name"`
+			Closed      bool     `json:"closed"`
+			DueComplete bool     `json:"dueComplete"`
+			Due         string   `json:"due"`
+			IDMembers   []string `json:"idMembers"`
+		} `json:"cards"`
+		Members []struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		} `json:"members"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]string)
+	for _, m := range export.Members {
+		members[m.ID] = m.Username
+	}
+
+	rows := make([]importRow, 0, len(export.Cards))
+	for _, card := range export.Cards {
+		row := importRow{
+			Text:      card.Name,
+			Completed: strconv.FormatBool(card.DueComplete || card.Closed),
+			CreatedAt: trelloCreatedAt(card.ID),
+			DueDate:   card.Due,
+		}
+		if len(card.IDMembers) > 0 {
+			row.User = members[card.IDMembers[0]]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Trello IDs start with the creation time as 8 hex digits of Unix seconds
+func trelloCreatedAt(id string) string {
+	if len(id) < 8 {
+		return ""
+	}
+	seconds, err := strconv.ParseInt(id[:8], 16, 64)
+	if err != nil {
+		return ""
+	}
+	return time.Unix(seconds, 0).Format(timestampFormat)
+}
+
+func parseImportBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "0", "false", "no", "n", "open", "pending", "todo":
+		return false, nil
+	case "1", "true", "yes", "y", "x", "done", "completed", "complete", "checked":
+		return true, nil
+	}
+	return false, fmt.Errorf("%q is not a completion value", value)
+}
+
+// Accept our own timestamps, RFC 3339 and plain dates
+func parseImportTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{timestampFormat, time.RFC3339, time.RFC3339Nano, "2006-01-02T15:04:05", dueDateFormat} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a recognised date", value)
+}
+
+// Match an imported assignee to an active user by username or email, both
+// regardless of case
+func resolveImportUser(ctx context.Context, name string) *User {
+	name = strings.TrimSpace(name)
+	if user := findUserByUsername(ctx, name); user != nil {
+		return user
+	}
+	for i, u := range users {
+		if u.DeletedAt == "" && u.Email != "" && strings.EqualFold(u.Email, name) {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// Validate rows and turn them into todos. Every problem is reported so a dry
+// run shows the whole picture at once.
+func validateImport(r *http.Request, rows []importRow, defaultUser *User) ([]Todo, []ImportError) {
+	var result []Todo
+	var problems []ImportError
+	now := time.Now()
+
+	for i, row := range rows {
+		rowNumber := i + 1
+		rowOK := true
+		fail := func(field, msg string) {
+			problems = append(problems, ImportError{Row: rowNumber, Field: field, Error: msg})
+			rowOK = false
+		}
+
+		todo := Todo{Text: row.Text}
+		if msg := todoTextRule.validate(&todo.Text); msg != "" {
+			fail("text", msg)
+		}
+
+		assignee := defaultUser
+		if name := strings.TrimSpace(row.User); name != "" {
+			if assignee = resolveImportUser(r.Context(), name); assignee == nil {
+				fail("user", fmt.Sprintf("Unknown user %q", name))
+			}
+		}
+		if assignee != nil {
+			if canActOnTodo(r, assignee.ID, PermTodosCreateOwn, PermTodosCreateAny) {
+				assignTodo(&todo, assignee)
+			} else {
+				fail("user", fmt.Sprintf("Not allowed to create todos for %q", assignee.Username))
+			}
+		}
+
+		completed, err := parseImportBool(row.Completed)
+		if err != nil {
+			fail("completed", err.Error())
+		}
+		todo.Completed = completed
+
+		todo.CreatedAt = now.Format(timestampFormat)
+		if strings.TrimSpace(row.CreatedAt) != "" {
+			created, err := parseImportTime(row.CreatedAt)
+			if err != nil {
+				fail("created_at", err.Error())
+			} else {
+				todo.CreatedAt = created.Local().Format(timestampFormat)
+			}
+		}
+
+		if strings.TrimSpace(row.DueDate) != "" {
+			due, err := parseImportTime(row.DueDate)
+			if err != nil {
+				fail("due_date", err.Error())
+			} else {
+				todo.DueDate = due.Local().Format(dueDateFormat)
+			}
+		}
+
+		if rowOK {
+			result = append(result, todo)
+		}
+	}
+	return result, problems
+}
+
+// A rejected import as a problem, with one field error per invalid row or value.
+// Rows are indexed from 0 here, like the other field paths.
+func importProblem(format string, total int, problems []ImportError) *api.Problem {
+	detail := fmt.Sprintf("%s import rejected: %d errors in %d rows. Nothing was imported.", format, len(problems), total)
+	problem := newProblem(http.StatusUnprocessableEntity, api.CodeImportFailed, detail)
+	for _, p := range problems {
+		field := fmt.Sprintf("rows[%d]", p.Row-1)
+		if p.Field != "" {
+			field += "." + p.Field
+		}
+		problem.Errors = append(problem.Errors, api.FieldError{Field: field, Code: api.CodeValidationFailed, Message: p.Error})
+	}
+	return problem
+}
+
+// POST /todos/import — Import todos from CSV, our JSON export, Todoist or Trello.
+// Nothing is saved unless every row is valid; ?dry_run=true only reports.
+func importTodos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	query := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		writeError(w, r, http.StatusRequestEntityTooLarge, api.CodePayloadTooLarge, "Import file is too large (max 10 MB)")
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = detectImportFormat(data)
+	}
+
+	// CSV columns can be picked explicitly, e.g. ?map_text=Title&map_user=Owner
+	mapping := make(map[string]string)
+	for field := range importColumnAliases {
+		if column := query.Get("map_" + field); column != "" {
+			mapping[field] = column
+		}
+	}
+
+	var rows []importRow
+	switch format {
+	case ImportCSV:
+		rows, err = parseCSVImport(data, mapping)
+	case ImportJSON:
+		rows, err = parseJSONImport(data)
+	case ImportTodoist:
+		rows, err = parseTodoistImport(data)
+	case ImportTrello:
+		rows, err = parseTrelloImport(data)
+	default:
+		writeFieldError(w, r, "format", "Format must be csv, json, todoist or trello")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Could not read "+format+" file: "+err.Error())
+		return
+	}
+
+	// Rows without an assignee go to ?default_user=, or to the caller.
+	// The lock covers matching users through saving the rows.
+	dataMu.Lock()
+	defaultUser := findUserByID(r.Context(), callerID(r))
+	if name := query.Get("default_user"); name != "" {
+		if defaultUser = resolveImportUser(r.Context(), name); defaultUser == nil {
+			dataMu.Unlock()
+			writeFieldError(w, r, "default_user", "Unknown default user "+name)
+			return
+		}
+	}
+
+	imported, problems := validateImport(r, rows, defaultUser)
+	result := ImportResult{
+		DryRun: dryRun,
+		Format: format,
+		Total:  len(rows),
+		Errors: problems,
+		Todos:  imported,
+	}
+	if result.Errors == nil {
+		result.Errors = []ImportError{}
+	}
+	if result.Todos == nil {
+		result.Todos = []Todo{}
+	}
+
+	// A dry run reports the errors and previews the valid rows
+	if dryRun {
+		dataMu.Unlock()
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	if len(problems) > 0 {
+		dataMu.Unlock()
+		writeProblem(w, r, importProblem(format, len(rows), problems))
+		return
+	}
+
+	// Every row is valid: save them all, or none if one can't be
+	for i := range imported {
+		imported[i].CreatedByID = callerID(r)
+	}
+	if imported, err = insertTodos(r.Context(), imported); err != nil {
+		dataMu.Unlock()
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Could not save todos")
+		return
+	}
+	// Imported todos are announced like todos created one by one
+	for _, todo := range imported {
+		publishTodo(EventTodoCreated, todo)
+		emitWebhook(WebhookTodoCreated, todo)
+		notifyUser(todo.UserID, NotifyAssigned, todo.CreatedBy, notificationData{Todo: todo})
+	}
+	dataMu.Unlock()
+	result.Imported = len(imported)
+	result.Todos = imported
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
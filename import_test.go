@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Imported todos reach webhook subscribers and their assignees, like todos
+// created through POST /todos
+func TestImportAnnouncesTodos(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	useWebhookDeliveries(t)
+	sink := useSMTPSink(t)
+	savedWebhooks := webhooks
+	t.Cleanup(func() { webhooks = savedWebhooks })
+	webhooks = []Webhook{{ID: 1, URL: "http://hooks.example.com/todos", Events: []string{WebhookTodoCreated}, Active: true}}
+	for i := range users {
+		users[i].Email = users[i].Username + "@example.com"
+	}
+	router := newRouter()
+	admin := logIn(t, router, "admin", "admin")
+
+	csv := "text,user\r\nBook the venue,alice\r\nOrder the cake,bob\r\nPlan my week,\r\n"
+	rec := doJSON(t, router, http.MethodPost, "/api/v1/todos/import?format=csv", csv, admin)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("import: status %d: %s", rec.Code, rec.Body)
+	}
+
+	webhooksMu.Lock()
+	var announced []string
+	for _, d := range webhookDeliveries {
+		var payload struct {
+			Event string `json:"event"`
+			Data  Todo   `json:"data"`
+		}
+		json.Unmarshal(d.Payload, &payload)
+		if d.Event != WebhookTodoCreated || payload.Event != WebhookTodoCreated {
+			t.Errorf("delivery for %s", d.Event)
+		}
+		announced = append(announced, payload.Data.Text)
+	}
+	webhooksMu.Unlock()
+	if strings.Join(announced, "|") != "Book the venue|Order the cake|Plan my week" {
+		t.Errorf("webhook deliveries for %q, want one per imported todo", announced)
+	}
+	if len(webhookQueue) != 3 {
+		t.Errorf("%d deliveries queued, want 3", len(webhookQueue))
+	}
+
+	// The caller is not told about the todo they imported for themselves
+	got := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		msg := sink.next(t)
+		got[msg.To[0]] = msg.Data
+	}
+	if !strings.Contains(got["alice@example.com"], "admin assigned you \"Book the venue\"") ||
+		!strings.Contains(got["bob@example.com"], "admin assigned you \"Order the cake\"") {
+		t.Errorf("assignment mail %v", got)
+	}
+	workers.Wait()
+	select {
+	case extra := <-sink.mail:
+		t.Errorf("unexpected mail to %v:\n%s", extra.To, extra.Data)
+	default:
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type requestIDKey struct{}
+
+// Build the logger from LOG_FORMAT ("text" or "json") and LOG_LEVEL
+// ("debug", "info", "warn" or "error")
+func loadLogger() *slog.Logger {
+	var level slog.Level
+	levelValue := os.Getenv("LOG_LEVEL")
+	levelErr := level.UnmarshalText([]byte(levelValue))
+	if levelValue == "" {
+		levelErr = nil
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	format := strings.ToLower(os.Getenv("LOG_FORMAT"))
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	logger := slog.New(contextHandler{handler})
+
+	if levelErr != nil {
+		logger.Warn("Invalid LOG_LEVEL, using info", "value", levelValue)
+	}
+	if format != "" && format != "text" && format != "json" {
+		logger.Warn("Invalid LOG_FORMAT, using text", "value", format)
+	}
+	return logger
+}
+
+// Adds the request ID and trace ID to every record logged with a request's context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if s := spanFromContext(ctx); s != nil {
+		record.AddAttrs(slog.String("trace_id", hex.EncodeToString(s.traceID[:])))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Log a recovered panic with the stack of the goroutine that panicked
+func logPanic(ctx context.Context, msg string, value interface{}) {
+	slog.ErrorContext(ctx, msg, "panic", value, "stack", string(debug.Stack()))
+}
+
+// Records the status and size of a response for the access log
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Lets http.ResponseController reach the underlying writer, e.g. to flush the event stream
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Log one line per request with its route, status, latency, size and user
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// The X-User-* headers are only trustworthy once authMiddleware has set them
+		r.Header.Del("X-User-ID")
+		r.Header.Del("X-User-Role")
+		r.Header.Del("X-User-Name")
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if probePaths[r.URL.Path] {
+			level = slog.LevelDebug
+		}
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		latency := time.Since(start)
+		observeRequest(r.Method, route, rec.status, latency)
+		slog.LogAttrs(r.Context(), level, "Request",
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", latency),
+			slog.Int("bytes", rec.bytes),
+			slog.String("user", r.Header.Get("X-User-Name")),
+		)
+	})
+}
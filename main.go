@@ -2,40 +2,45 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+
+	"to-do-list/api"
 )
 
-type Todo struct {
-	ID        int    `json:"id"`
-	Text      string `json:"text"`
-	Completed bool   `json:"completed"`
-	User      string `json:"user"`
-	CreatedAt string `json:"created_at"`
-}
+// The API representation is also the stored form
+type Todo = api.Todo
 
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Role     string `json:"role"`
-}
-
-type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
-
-type UpdatePasswordRequest struct {
-	CurrentPassword string `json:"currentPassword"`
-	NewPassword     string `json:"newPassword"`
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Password    string `json:"password"`
+	Role        string `json:"role"`
+	Email       string `json:"email,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	OIDCSubject string `json:"-"`                    // Linked SSO identity, empty for local-only accounts
+	DeletedAt   string `json:"deleted_at,omitempty"` // Set while the user is in the trash
+
+	// Stored in each session at login. Bumping it logs out every session the user has.
+	SessionVersion int `json:"-"`
+
+	// Two-factor authentication
+	TOTPEnabled       bool     `json:"totp_enabled"`
+	TOTPRequired      bool     `json:"totp_required"` // Enforced by an admin
+	TOTPSecret        string   `json:"-"`
+	TOTPPendingSecret string   `json:"-"` // Secret awaiting confirmation during enrollment
+	TOTPLastStep      int64    `json:"-"` // Last accepted time step, prevents code replay
+	RecoveryCodes     []string `json:"-"` // SHA-256 hashes of unused recovery codes
+
+	Notifications *NotificationPreferences `json:"-"` // nil means the defaults
 }
 
 type SessionData struct {
@@ -44,12 +49,20 @@ type SessionData struct {
 	Role     string `json:"role"`
 }
 
+// Layout for CreatedAt and DeletedAt timestamps
+const timestampFormat = "2006-01-02 15:04:05"
+
 var todos []Todo
 var users []User
 var nextID = 1
 var nextUserID = 1
 var store *sessions.CookieStore
 
+// Guards todos, users, comments and the ID counters. Handlers hold it only
+// while they read or change them: after the request body has been decoded, and
+// never across network I/O. Lists are copied out before they are encoded.
+var dataMu sync.RWMutex
+
 func init() {
 	// Initialize session store once
 	store = sessions.NewCookieStore([]byte("todo-app-secret-key-very-long-and-secure"))
@@ -57,18 +70,19 @@ func init() {
 		Path:     "/",
 		MaxAge:   86400 * 7, // 7 days
 		HttpOnly: false,     // Set to false for debugging
-		Secure:   false,     // Set to true in production with HTTPS
+		Secure:   false,     // Switched on by loadTLSConfig when serving HTTPS
 		SameSite: http.SameSiteLaxMode,
 	}
 }
 
 // Recovery middleware to prevent server crashes
 func recoveryMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	next = traceHandler(next)
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			if r := recover(); r != nil {
-				fmt.Printf("Handler panic recovered: %v\n", r)
-				http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+			if err := recover(); err != nil {
+				logPanic(r.Context(), "Handler panic recovered", err)
+				writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Internal server error")
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -81,22 +95,45 @@ func getTodos(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-	// Check for user filter query parameter
-	userFilter := r.URL.Query().Get("user")
+	filteredTodos, ok := visibleTodos(w, r)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(filteredTodos)
+}
 
-	if userFilter != "" {
-		// Filter todos by user
-		var filteredTodos []Todo
-		for _, todo := range todos {
-			if todo.User == userFilter {
-				filteredTodos = append(filteredTodos, todo)
-			}
+// Todos the caller may read, narrowed by the optional ?user= filter (a username)
+// and leaving out anything in the trash. Writes the error response when reading
+// is forbidden.
+func visibleTodos(w http.ResponseWriter, r *http.Request) ([]Todo, bool) {
+	dataMu.RLock()
+	defer dataMu.RUnlock()
+
+	userFilter := -1
+	if username := r.URL.Query().Get("user"); username != "" {
+		// Unknown users simply have no todos
+		userFilter = 0
+		if user := usernameOwner(username, 0); user != nil {
+			userFilter = user.ID
+		}
+	}
+
+	// Without todos:read:any, callers only ever see their own todos
+	if !hasPermission(r, PermTodosReadAny) {
+		if !hasPermission(r, PermTodosReadOwn) {
+			writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden - todos:read:own permission required")
+			return nil, false
+		}
+		userFilter = callerID(r)
+	}
+
+	filteredTodos := []Todo{}
+	for _, todo := range todos {
+		if todo.DeletedAt == "" && (userFilter < 0 || todo.UserID == userFilter) {
+			filteredTodos = append(filteredTodos, todo)
 		}
-		json.NewEncoder(w).Encode(filteredTodos)
-	} else {
-		// Return all todos
-		json.NewEncoder(w).Encode(todos)
 	}
+	return filteredTodos, true
 }
 
 // POST /todos — Add a new todo
@@ -107,17 +144,45 @@ func addTodo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-	var newTodo Todo
-	if err := json.NewDecoder(r.Body).Decode(&newTodo); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	var req api.NewTodo
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	errs := newTodoRules(&req).validate()
+	tags, msg := normalizeTags(req.Tags)
+	if msg != "" {
+		errs = append(errs, api.FieldError{Field: "tags", Code: api.CodeValidationFailed, Message: msg})
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, r, errs)
 		return
 	}
+	newTodo := Todo{Text: req.Text, DueDate: req.DueDate, Tags: tags}
 
-	newTodo.ID = nextID
-	nextID++
-	newTodo.Completed = false
-	newTodo.CreatedAt = time.Now().Format("2006-01-02 15:04:05")
-	todos = append(todos, newTodo)
+	// Todos default to the caller; assigning to someone else needs todos:create:any
+	newTodo.UserID = callerID(r)
+	if req.User != "" {
+		newTodo.UserID = findUserByUsername(r.Context(), req.User).ID
+	}
+	if !canActOnTodo(r, newTodo.UserID, PermTodosCreateOwn, PermTodosCreateAny) {
+		writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden - cannot create todos for this user")
+		return
+	}
+
+	newTodo.CreatedAt = time.Now().Format(timestampFormat)
+	newTodo.CreatedByID = callerID(r)
+	newTodo, err := insertTodo(r.Context(), newTodo)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Could not save todo")
+		return
+	}
+	publishTodo(EventTodoCreated, newTodo)
+	emitWebhook(WebhookTodoCreated, newTodo)
+	notifyUser(newTodo.UserID, NotifyAssigned, newTodo.CreatedBy, notificationData{Todo: newTodo})
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newTodo)
@@ -134,20 +199,36 @@ func completeTodo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, `{"error": "Invalid ID"}`, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid ID")
 		return
 	}
 
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
 	// Find and complete todo
 	for i, todo := range todos {
-		if todo.ID == id {
+		if todo.ID == id && todo.DeletedAt == "" {
+			if !canActOnTodo(r, todo.UserID, PermTodosCompleteOwn, PermTodosCompleteAny) {
+				writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden - cannot complete this todo")
+				return
+			}
 			todos[i].Completed = true
+			publishTodo(EventTodoUpdated, todos[i])
+			emitWebhook(WebhookTodoCompleted, todos[i])
+
+			// Tell the assignee and whoever created the todo
+			actor := r.Header.Get("X-User-Name")
+			notifyUser(todo.UserID, NotifyCompleted, actor, notificationData{Todo: todos[i]})
+			if todo.CreatedByID != todo.UserID {
+				notifyUser(todo.CreatedByID, NotifyCompleted, actor, notificationData{Todo: todos[i]})
+			}
 			json.NewEncoder(w).Encode(map[string]string{"message": "Todo completed successfully"})
 			return
 		}
 	}
 
-	http.Error(w, `{"error": "Todo not found"}`, http.StatusNotFound)
+	writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Todo not found")
 }
 
 func deleteTodo(w http.ResponseWriter, r *http.Request) {
@@ -158,54 +239,126 @@ func deleteTodo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid ID")
 		return
 	}
 
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	purgeExpiredTrash(r.Context())
+
+	// Move the todo to the trash; it can be restored until the retention period ends
 	for i, todo := range todos {
-		if todo.ID == id {
-			todos = append(todos[:i], todos[i+1:]...)
+		if todo.ID == id && todo.DeletedAt == "" {
+			if !canActOnTodo(r, todo.UserID, PermTodosDeleteOwn, PermTodosDeleteAny) {
+				writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden - cannot delete this todo")
+				return
+			}
+			todos[i].DeletedAt = time.Now().Format(timestampFormat)
+			publishTodo(EventTodoDeleted, todos[i])
+			emitWebhook(WebhookTodoDeleted, todos[i])
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 	}
 
-	http.Error(w, "Todo not found", http.StatusNotFound)
+	writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Todo not found")
 }
 
 // Authentication middleware
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return authenticate(next, false)
+}
+
+// Authentication middleware for 2FA enrollment, which must stay reachable while
+// an admin-enforced enrollment is still outstanding
+func enrollmentAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return authenticate(next, true)
+}
+
+func authenticate(next http.HandlerFunc, allowPendingEnrollment bool) http.HandlerFunc {
+	next = traceHandler(next)
 	return func(w http.ResponseWriter, r *http.Request) {
-		session, err := store.Get(r, "todo-session")
-		if err != nil {
-			http.Error(w, `{"error": "Session error"}`, http.StatusUnauthorized)
-			return
+		ctx, span := startSpan(r.Context(), "authMiddleware", spanInternal)
+		ok := authenticateSession(w, r.WithContext(ctx), allowPendingEnrollment)
+		span.setAttr("auth.ok", ok)
+		span.finish()
+		if ok {
+			next.ServeHTTP(w, r)
 		}
+	}
+}
 
-		userID, ok := session.Values["user_id"]
-		if !ok {
-			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-			return
-		}
+// Check the session and add the user to the request headers, or write the error response
+func authenticateSession(w http.ResponseWriter, r *http.Request, allowPendingEnrollment bool) bool {
+	session, err := store.Get(r, "todo-session")
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Session error")
+		return false
+	}
 
-		// Add user info to request context
-		r.Header.Set("X-User-ID", fmt.Sprintf("%v", userID))
-		r.Header.Set("X-User-Role", fmt.Sprintf("%v", session.Values["role"]))
-		r.Header.Set("X-User-Name", fmt.Sprintf("%v", session.Values["username"]))
+	userID, ok := session.Values["user_id"]
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return false
+	}
 
-		next.ServeHTTP(w, r)
+	// Resolve the user on every request so role changes and deletions apply immediately
+	id, _ := userID.(int)
+	dataMu.RLock()
+	var user User
+	found := findUserByID(r.Context(), id)
+	if found != nil {
+		user = *found
+	}
+	dataMu.RUnlock()
+	if found == nil {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return false
+	}
+	if !sessionIsCurrent(session, &user) {
+		untrackSession(session)
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Session has been revoked, please log in again")
+		return false
 	}
+	// Checked on the user rather than the session, so a requirement an admin
+	// turns on applies to sessions that are already open
+	if user.TOTPRequired && !user.TOTPEnabled && !allowPendingEnrollment {
+		writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Two-factor enrollment required")
+		return false
+	}
+
+	touchSession(session, &user)
+
+	// Add user info to request context
+	r.Header.Set("X-User-ID", strconv.Itoa(user.ID))
+	r.Header.Set("X-User-Role", user.Role)
+	r.Header.Set("X-User-Name", user.Username)
+	return true
 }
 
-// Admin middleware
-func adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		role := r.Header.Get("X-User-Role")
-		if role != "admin" {
-			http.Error(w, "Forbidden - Admin access required", http.StatusForbidden)
-			return
-		}
-		next.ServeHTTP(w, r)
+// The public view of a user
+func apiUser(user User) api.User {
+	return api.User{
+		ID:           user.ID,
+		Username:     user.Username,
+		DisplayName:  user.DisplayName,
+		Role:         user.Role,
+		Email:        user.Email,
+		AvatarURL:    user.AvatarURL,
+		TOTPEnabled:  user.TOTPEnabled,
+		TOTPRequired: user.TOTPRequired,
+	}
+}
+
+// Response for a completed login, after the second factor if there is one
+func loginResponse(user *User) api.LoginResponse {
+	return api.LoginResponse{
+		Message:                "Login successful",
+		User:                   &api.LoginUser{ID: user.ID, Username: user.Username, DisplayName: user.DisplayName, Role: user.Role},
+		Permissions:            rolePermissions(user.Role),
+		TwoFactorSetupRequired: user.TOTPRequired && !user.TOTPEnabled,
 	}
 }
 
@@ -217,53 +370,79 @@ func login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-	var loginReq LoginRequest
+	var loginReq api.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&loginReq); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
 		return
 	}
 
-	// Find user
+	// Find user (SSO-only accounts have no password and cannot log in here)
 	var user *User
+	dataMu.RLock()
 	for _, u := range users {
-		if u.Username == loginReq.Username && u.Password == loginReq.Password {
+		if sameUsername(u.Username, loginReq.Username) && u.DeletedAt == "" && u.Password != "" && u.Password == loginReq.Password {
 			user = &u
 			break
 		}
 	}
+	dataMu.RUnlock()
 
 	if user == nil {
-		http.Error(w, `{"error": "Invalid credentials"}`, http.StatusUnauthorized)
+		recordLogin("password", false)
+		writeError(w, r, http.StatusUnauthorized, api.CodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
 	// Create session
 	session, err := store.Get(r, "todo-session")
 	if err != nil {
-		http.Error(w, `{"error": "Session error"}`, http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Session error")
 		return
 	}
 
-	session.Values["user_id"] = user.ID
-	session.Values["username"] = user.Username
-	session.Values["role"] = user.Role
+	twoFactorRequired := beginLogin(session, user)
 
 	err = session.Save(r, w)
 	if err != nil {
-		http.Error(w, `{"error": "Session error"}`, http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Session error")
 		return
 	}
 
-	response := map[string]interface{}{
-		"message": "Login successful",
-		"user": map[string]interface{}{
-			"id":       user.ID,
-			"username": user.Username,
-			"role":     user.Role,
-		},
+	// The session is not logged in until the second step succeeds
+	if twoFactorRequired {
+		json.NewEncoder(w).Encode(api.LoginResponse{
+			Message:           "Two-factor authentication required",
+			TwoFactorRequired: true,
+		})
+		return
 	}
 
-	json.NewEncoder(w).Encode(response)
+	recordLogin("password", true)
+	json.NewEncoder(w).Encode(loginResponse(user))
+}
+
+// Store the logged-in user's identity in the session
+func setSessionUser(session *sessions.Session, user *User) {
+	session.Values["user_id"] = user.ID
+	session.Values["username"] = user.Username
+	session.Values["role"] = user.Role
+	session.Values["auth_time"] = time.Now().Unix()
+	session.Values["session_version"] = user.SessionVersion
+	trackSession(session, user)
+}
+
+// Whether a session was logged in after the user's sessions were last revoked
+func sessionIsCurrent(session *sessions.Session, user *User) bool {
+	version, _ := session.Values["session_version"].(int)
+	return version == user.SessionVersion
+}
+
+// Log out every session of a user. Sessions live in their cookies, so they are
+// turned away by sessionIsCurrent from now on. Must be called with dataMu held.
+func revokeSessions(user *User) {
+	user.SessionVersion++
+	untrackUserSessions(user.ID)
+	hub.CloseUser(user.ID)
 }
 
 // Logout endpoint
@@ -279,6 +458,10 @@ func logout(w http.ResponseWriter, r *http.Request) {
 		session.Values["user_id"] = nil
 		session.Values["username"] = nil
 		session.Values["role"] = nil
+		untrackSession(session)
+		if id, ok := session.Values["session_id"].(string); ok {
+			hub.CloseSession(id)
+		}
 		session.Options.MaxAge = -1
 		session.Save(r, w)
 	}
@@ -296,23 +479,39 @@ func getCurrentUser(w http.ResponseWriter, r *http.Request) {
 	session, err := store.Get(r, "todo-session")
 	if err != nil {
 		// If session is invalid, treat as not logged in
-		http.Error(w, `{"error": "Not logged in"}`, http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Not logged in")
 		return
 	}
 
 	userID, ok := session.Values["user_id"]
 	if !ok {
-		http.Error(w, `{"error": "Not logged in"}`, http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Not logged in")
 		return
 	}
 
-	user := map[string]interface{}{
-		"id":       userID,
-		"username": session.Values["username"],
-		"role":     session.Values["role"],
+	role, _ := session.Values["role"].(string)
+	username, _ := session.Values["username"].(string)
+	var displayName string
+	id, _ := userID.(int)
+	dataMu.RLock()
+	u := findUserByID(r.Context(), id)
+	revoked := u != nil && !sessionIsCurrent(session, u)
+	if u != nil {
+		role, username, displayName = u.Role, u.Username, u.DisplayName
+	}
+	dataMu.RUnlock()
+	if revoked {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Not logged in")
+		return
 	}
 
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(api.CurrentUser{
+		ID:          id,
+		Username:    username,
+		DisplayName: displayName,
+		Role:        role,
+		Permissions: rolePermissions(role),
+	})
 }
 
 // Create user (admin only)
@@ -321,49 +520,59 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-	var newUser User
-	if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	var req api.CreateUserRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	// Validate username
-	if len(newUser.Username) == 0 {
-		http.Error(w, `{"error": "Username is required"}`, http.StatusBadRequest)
-		return
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	errs := newUserRules(&req).validate()
+	if msg := passwordPolicy.Validate(req.Password, req.Username); msg != "" {
+		errs = append(errs, api.FieldError{Field: "password", Code: api.CodeValidationFailed, Message: msg})
 	}
-	if len(newUser.Username) > 15 {
-		http.Error(w, `{"error": "Username must be 15 characters or less"}`, http.StatusBadRequest)
-		return
+	if req.Role == "" {
+		req.Role = "user" // Default role
+	} else if !roleExists(req.Role) {
+		errs = append(errs, api.FieldError{Field: "role", Code: api.CodeValidationFailed, Message: "Role does not exist"})
 	}
-	if strings.Contains(newUser.Username, " ") {
-		http.Error(w, `{"error": "Username cannot contain spaces"}`, http.StatusBadRequest)
+	if len(errs) > 0 {
+		writeFieldErrors(w, r, errs)
 		return
 	}
-	// Check for special characters - only allow alphanumeric characters
-	for _, char := range newUser.Username {
-		if !((char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')) {
-			http.Error(w, `{"error": "Username can only contain letters and numbers"}`, http.StatusBadRequest)
-			return
-		}
-	}
 
-	// Check if username already exists
-	for _, u := range users {
-		if u.Username == newUser.Username {
-			http.Error(w, `{"error": "Username already exists"}`, http.StatusConflict)
+	// Check if username or email is taken (trashed users keep theirs until purged)
+	if owner := usernameOwner(req.Username, 0); owner != nil {
+		if owner.DeletedAt != "" {
+			writeError(w, r, http.StatusConflict, api.CodeConflict, "Username belongs to a deleted user. Restore or purge it first.")
 			return
 		}
+		writeError(w, r, http.StatusConflict, api.CodeConflict, "Username already exists")
+		return
+	}
+	if emailOwner(req.Email, 0) != nil {
+		writeError(w, r, http.StatusConflict, api.CodeConflict, "Email is already used by another user")
+		return
 	}
 
-	newUser.ID = nextUserID
+	// Users enroll in 2FA themselves via /2fa/setup
+	newUser := User{
+		ID:          nextUserID,
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
+		Password:    req.Password,
+		Role:        req.Role,
+		Email:       req.Email,
+		AvatarURL:   req.AvatarURL,
+	}
 	nextUserID++
-	newUser.Role = "user" // Default role
 
 	users = append(users, newUser)
+	publishUser(EventUserCreated, newUser)
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newUser)
+	json.NewEncoder(w).Encode(apiUser(newUser))
 }
 
 // Get all users (admin only)
@@ -373,15 +582,14 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 	// Return users without passwords
-	var safeUsers []map[string]interface{}
+	safeUsers := []api.User{}
+	dataMu.RLock()
 	for _, user := range users {
-		safeUser := map[string]interface{}{
-			"id":       user.ID,
-			"username": user.Username,
-			"role":     user.Role,
+		if user.DeletedAt == "" {
+			safeUsers = append(safeUsers, apiUser(user))
 		}
-		safeUsers = append(safeUsers, safeUser)
 	}
+	dataMu.RUnlock()
 
 	json.NewEncoder(w).Encode(safeUsers)
 }
@@ -394,9 +602,9 @@ func updatePassword(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-	var updateReq UpdatePasswordRequest
+	var updateReq api.UpdatePasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
 		return
 	}
 
@@ -404,10 +612,13 @@ func updatePassword(w http.ResponseWriter, r *http.Request) {
 	session, _ := store.Get(r, "todo-session")
 	userID, ok := session.Values["user_id"].(int)
 	if !ok {
-		http.Error(w, `{"error": "Not logged in"}`, http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Not logged in")
 		return
 	}
 
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
 	// Find user
 	var user *User
 	for i, u := range users {
@@ -418,13 +629,25 @@ func updatePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if user == nil {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "User not found")
+		return
+	}
+
+	// Accounts that sign in through SSO have no password. An empty current
+	// password would match it, so a session alone must not be enough to add one.
+	if user.Password == "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "This account signs in with single sign-on and has no password to change")
 		return
 	}
 
 	// Verify current password
 	if user.Password != updateReq.CurrentPassword {
-		http.Error(w, `{"error": "Current password is incorrect"}`, http.StatusBadRequest)
+		writeFieldError(w, r, "currentPassword", "Current password is incorrect")
+		return
+	}
+
+	if msg := passwordPolicy.Validate(updateReq.NewPassword, user.Username); msg != "" {
+		writeFieldError(w, r, "newPassword", msg)
 		return
 	}
 
@@ -435,12 +658,6 @@ func updatePassword(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Password updated successfully"})
 }
 
-type UpdateUserRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password,omitempty"`
-	Role     string `json:"role"`
-}
-
 func updateUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
@@ -453,74 +670,81 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	userIDStr := vars["id"]
 	userID, err := strconv.Atoi(userIDStr)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid user ID")
 		return
 	}
 
-	var updateReq UpdateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+	var updateReq api.UpdateUserRequest
+	if !decodeJSON(w, r, &updateReq) {
 		return
 	}
 
-	// Validate username
-	if len(updateReq.Username) == 0 {
-		http.Error(w, `{"error": "Username is required"}`, http.StatusBadRequest)
-		return
-	}
-	if len(updateReq.Username) > 15 {
-		http.Error(w, `{"error": "Username must be 15 characters or less"}`, http.StatusBadRequest)
-		return
-	}
-	if strings.Contains(updateReq.Username, " ") {
-		http.Error(w, `{"error": "Username cannot contain spaces"}`, http.StatusBadRequest)
-		return
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	errs := updateUserRules(&updateReq).validate()
+	if !roleExists(updateReq.Role) {
+		errs = append(errs, api.FieldError{Field: "role", Code: api.CodeValidationFailed, Message: "Role does not exist"})
 	}
-	// Check for special characters - only allow alphanumeric characters
-	for _, char := range updateReq.Username {
-		if !((char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')) {
-			http.Error(w, `{"error": "Username can only contain letters and numbers"}`, http.StatusBadRequest)
-			return
+	// Password is optional on update, but must follow the policy when given
+	if updateReq.Password != "" {
+		if msg := passwordPolicy.Validate(updateReq.Password, updateReq.Username); msg != "" {
+			errs = append(errs, api.FieldError{Field: "password", Code: api.CodeValidationFailed, Message: msg})
 		}
 	}
-
-	// Validate role
-	if updateReq.Role != "admin" && updateReq.Role != "user" {
-		http.Error(w, `{"error": "Role must be 'admin' or 'user'"}`, http.StatusBadRequest)
+	if len(errs) > 0 {
+		writeFieldErrors(w, r, errs)
 		return
 	}
 
 	// Find user
-	var user *User
-	for i, u := range users {
-		if u.ID == userID {
-			user = &users[i]
-			break
-		}
+	user := findUserByID(r.Context(), userID)
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "User not found")
+		return
 	}
 
-	if user == nil {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+	// Prevent demoting the last user who can manage users
+	if roleHasPermission(user.Role, PermUsersManage) && !roleHasPermission(updateReq.Role, PermUsersManage) && countUserManagers(user.ID) == 0 {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Cannot remove user management from the last user who has it")
 		return
 	}
 
-	// Check if username already exists (excluding current user)
-	for _, u := range users {
-		if u.Username == updateReq.Username && u.ID != userID {
-			http.Error(w, `{"error": "Username already exists"}`, http.StatusBadRequest)
-			return
-		}
+	// Check if username or email is taken by someone else
+	if usernameOwner(updateReq.Username, userID) != nil {
+		writeFieldError(w, r, "username", "Username already exists")
+		return
+	}
+	if updateReq.Email != nil && emailOwner(*updateReq.Email, userID) != nil {
+		writeFieldError(w, r, "email", "Email is already used by another user")
+		return
 	}
 
 	// Update user
 	user.Username = updateReq.Username
 	user.Role = updateReq.Role
+	if updateReq.DisplayName != nil {
+		user.DisplayName = *updateReq.DisplayName
+	}
+	if updateReq.Email != nil {
+		user.Email = *updateReq.Email
+	}
+	if updateReq.AvatarURL != nil {
+		user.AvatarURL = *updateReq.AvatarURL
+	}
 
 	// Only update password if provided
 	if updateReq.Password != "" {
 		user.Password = updateReq.Password
 	}
 
+	publishUser(EventUserUpdated, *user)
+
+	// Todos show the user's name and profile, so clients get the new ones
+	for _, todo := range refreshUserTodos(user) {
+		publishTodo(EventTodoUpdated, todo)
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "User updated successfully"})
 }
@@ -537,60 +761,70 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 	userIDStr := vars["id"]
 	userID, err := strconv.Atoi(userIDStr)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid user ID")
 		return
 	}
 
-	// Find user index
-	userIndex := -1
-	var userToDelete *User
-	for i, u := range users {
-		if u.ID == userID {
-			userIndex = i
-			userToDelete = &users[i]
-			break
-		}
-	}
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	purgeExpiredTrash(r.Context())
 
-	if userIndex == -1 {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+	userToDelete := findUserByID(r.Context(), userID)
+	if userToDelete == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "User not found")
 		return
 	}
 
-	// Check if user is admin
-	if userToDelete.Role == "admin" {
-		// Count remaining admin users (excluding the one being deleted)
-		adminCount := 0
-		for i, u := range users {
-			if u.Role == "admin" && i != userIndex {
-				adminCount++
-			}
-		}
+	// Prevent deleting the last user who can manage users
+	if roleHasPermission(userToDelete.Role, PermUsersManage) && countUserManagers(userToDelete.ID) == 0 {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Cannot delete the last admin user. At least one admin must remain in the system.")
+		return
+	}
 
-		// Prevent deletion if this is the last admin
-		if adminCount == 0 {
-			http.Error(w, `{"error": "Cannot delete the last admin user. At least one admin must remain in the system."}`, http.StatusBadRequest)
+	// Optionally hand the user's todos to someone else instead of trashing them
+	reassignTo := r.URL.Query().Get("reassign_to")
+	var target *User
+	if reassignTo != "" {
+		target = findUserByUsername(r.Context(), reassignTo)
+		if target == nil || target.ID == userID {
+			writeFieldError(w, r, "reassign_to", "Cannot reassign todos to that user")
 			return
 		}
 	}
 
-	// Get username for logging
-	username := users[userIndex].Username
+	deletedAt := time.Now().Format(timestampFormat)
 
-	// Remove user from users slice
-	users = append(users[:userIndex], users[userIndex+1:]...)
+	// Move the user to the trash
+	userToDelete.DeletedAt = deletedAt
+	publishUser(EventUserDeleted, *userToDelete)
 
-	// Remove all todos created by this user
-	var remainingTodos []Todo
-	for _, todo := range todos {
-		if todo.User != username {
-			remainingTodos = append(remainingTodos, todo)
+	affected := 0
+	for i, todo := range todos {
+		if todo.UserID != userID || todo.DeletedAt != "" {
+			continue
+		}
+		if target != nil {
+			assignTodo(&todos[i], target)
+			publishTodo(EventTodoUpdated, todos[i])
+		} else {
+			todos[i].DeletedAt = deletedAt
+			todos[i].DeletedWithUser = true
+			publishTodo(EventTodoDeleted, todos[i])
 		}
+		affected++
+	}
+
+	response := api.DeleteUserResponse{Message: "User deleted successfully"}
+	if target != nil {
+		response.ReassignedTodos = affected
+		response.ReassignedTo = target.Username
+	} else {
+		response.TrashedTodos = affected
 	}
-	todos = remainingTodos
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "User deleted successfully"})
+	json.NewEncoder(w).Encode(response)
 }
 
 // Handle preflight OPTIONS requests for CORS
@@ -602,32 +836,167 @@ func handleOptions(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// Build the router: every endpoint under /api/v1, the same endpoints at their
+// old unversioned paths as deprecated aliases, and the frontend at /
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(requestIDMiddleware)
+	r.Use(tracingMiddleware)
+	r.Use(accessLogMiddleware)
+	// Turn away callers over their limit before anything else is done for them
+	r.Use(rateLimitMiddleware)
+	r.Use(bodyLimitMiddleware)
+	r.NotFoundHandler = requestIDMiddleware(tracingMiddleware(accessLogMiddleware(http.HandlerFunc(notFound))))
+	r.MethodNotAllowedHandler = requestIDMiddleware(tracingMiddleware(accessLogMiddleware(http.HandlerFunc(methodNotAllowed))))
+
+	r.HandleFunc(api.Prefix+"/openapi.json", serveOpenAPI).Methods("GET")
+	r.HandleFunc(api.Prefix+"/openapi.json", handleOptions).Methods("OPTIONS")
+	registerAPIRoutes(r.PathPrefix(api.Prefix).Subrouter())
+
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(deprecatedAliasMiddleware)
+	registerAPIRoutes(legacy)
+
+	r.HandleFunc("/metrics", serveMetrics).Methods("GET")
+	r.HandleFunc("/healthz", healthz).Methods("GET")
+	r.HandleFunc("/readyz", readyz).Methods("GET")
+	r.HandleFunc("/version", version).Methods("GET")
+
+	// Serve index.html as root
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "index.html")
+	})
+
+	return r
+}
+
+// Register the API endpoints, relative to the router's path prefix
+func registerAPIRoutes(api *mux.Router) {
+	// Authentication routes
+	api.HandleFunc("/login", recoveryMiddleware(login)).Methods("POST")
+	api.HandleFunc("/logout", recoveryMiddleware(logout)).Methods("POST")
+	api.HandleFunc("/me", recoveryMiddleware(getCurrentUser)).Methods("GET")
+	api.HandleFunc("/update-password", recoveryMiddleware(authMiddleware(updatePassword))).Methods("POST")
+	api.HandleFunc("/me/notifications", recoveryMiddleware(authMiddleware(getNotificationPreferences))).Methods("GET")
+	api.HandleFunc("/me/notifications", recoveryMiddleware(authMiddleware(updateNotificationPreferences))).Methods("PUT")
+
+	// Password reset routes
+	api.HandleFunc("/password-reset/request", recoveryMiddleware(requestPasswordReset)).Methods("POST")
+	api.HandleFunc("/password-reset/confirm", recoveryMiddleware(confirmPasswordReset)).Methods("POST")
+
+	// Two-factor authentication routes
+	api.HandleFunc("/login/2fa", recoveryMiddleware(loginSecondFactor)).Methods("POST")
+	api.HandleFunc("/2fa/setup", recoveryMiddleware(enrollmentAuthMiddleware(setupTwoFactor))).Methods("POST")
+	api.HandleFunc("/2fa/enable", recoveryMiddleware(enrollmentAuthMiddleware(enableTwoFactor))).Methods("POST")
+	api.HandleFunc("/2fa/disable", recoveryMiddleware(authMiddleware(disableTwoFactor))).Methods("POST")
+	api.HandleFunc("/2fa/recovery-codes", recoveryMiddleware(authMiddleware(regenerateRecoveryCodes))).Methods("POST")
+
+	// Single sign-on routes
+	api.HandleFunc("/auth/oidc/config", recoveryMiddleware(oidcStatus)).Methods("GET")
+	api.HandleFunc("/auth/oidc/login", recoveryMiddleware(oidcLogin)).Methods("GET")
+	api.HandleFunc("/auth/oidc/callback", recoveryMiddleware(oidcCallback)).Methods("GET")
+
+	// User management routes
+	api.HandleFunc("/admin/users/trash", authMiddleware(requirePermission(PermUsersManage, getUserTrash))).Methods("GET")
+	api.HandleFunc("/admin/users/trash/{id}", authMiddleware(requirePermission(PermUsersManage, purgeUser))).Methods("DELETE")
+	api.HandleFunc("/admin/users/export", authMiddleware(requirePermission(PermUsersManage, exportUsers))).Methods("GET")
+	api.HandleFunc("/admin/users", authMiddleware(requirePermission(PermUsersRead, getUsers))).Methods("GET")
+	api.HandleFunc("/admin/users", authMiddleware(requirePermission(PermUsersManage, createUser))).Methods("POST")
+	api.HandleFunc("/admin/users/{id}", authMiddleware(requirePermission(PermUsersManage, updateUser))).Methods("PUT")
+	api.HandleFunc("/admin/users/{id}", authMiddleware(requirePermission(PermUsersManage, deleteUser))).Methods("DELETE")
+	api.HandleFunc("/admin/users/{id}/2fa", authMiddleware(requirePermission(PermUsersManage, setTwoFactorPolicy))).Methods("PUT")
+	api.HandleFunc("/admin/users/{id}/2fa", authMiddleware(requirePermission(PermUsersManage, resetUserTwoFactor))).Methods("DELETE")
+
+	// Role management routes
+	api.HandleFunc("/admin/permissions", authMiddleware(requirePermission(PermRolesManage, getPermissions))).Methods("GET")
+	api.HandleFunc("/admin/roles", authMiddleware(requirePermission(PermUsersRead, getRoles))).Methods("GET")
+	api.HandleFunc("/admin/roles", authMiddleware(requirePermission(PermRolesManage, createRole))).Methods("POST")
+	api.HandleFunc("/admin/roles/{name}", authMiddleware(requirePermission(PermRolesManage, updateRole))).Methods("PUT")
+	api.HandleFunc("/admin/roles/{name}", authMiddleware(requirePermission(PermRolesManage, deleteRole))).Methods("DELETE")
+
+	// Webhook routes
+	api.HandleFunc("/admin/webhooks", authMiddleware(requirePermission(PermWebhooksManage, getWebhooks))).Methods("GET")
+	api.HandleFunc("/admin/webhooks", authMiddleware(requirePermission(PermWebhooksManage, createWebhook))).Methods("POST")
+	api.HandleFunc("/admin/webhooks/{id}", authMiddleware(requirePermission(PermWebhooksManage, updateWebhook))).Methods("PUT")
+	api.HandleFunc("/admin/webhooks/{id}", authMiddleware(requirePermission(PermWebhooksManage, deleteWebhook))).Methods("DELETE")
+	api.HandleFunc("/admin/webhooks/{id}/deliveries", authMiddleware(requirePermission(PermWebhooksManage, getWebhookDeliveries))).Methods("GET")
+	api.HandleFunc("/admin/webhooks/deliveries/{id}/redeliver", authMiddleware(requirePermission(PermWebhooksManage, redeliverWebhook))).Methods("POST")
+
+	// Trash routes (registered before /todos/{id} and /admin/users/{id} so "trash" isn't taken as an ID)
+	api.HandleFunc("/todos/trash", recoveryMiddleware(authMiddleware(getTodoTrash))).Methods("GET")
+	api.HandleFunc("/todos/trash/{id}", recoveryMiddleware(authMiddleware(purgeTodo))).Methods("DELETE")
+	api.HandleFunc("/todos/{id}/restore", recoveryMiddleware(authMiddleware(restoreTodo))).Methods("POST")
+	api.HandleFunc("/admin/users/{id}/restore", authMiddleware(requirePermission(PermUsersManage, restoreUser))).Methods("POST")
+
+	// Live updates
+	api.HandleFunc("/events", recoveryMiddleware(authMiddleware(streamEvents))).Methods("GET")
+
+	// Todo routes (authenticated users)
+	api.HandleFunc("/todos", recoveryMiddleware(authMiddleware(getTodos))).Methods("GET")
+	api.HandleFunc("/todos/export", recoveryMiddleware(authMiddleware(exportTodos))).Methods("GET")
+	api.HandleFunc("/todos/import", recoveryMiddleware(authMiddleware(importTodos))).Methods("POST")
+	api.HandleFunc("/todos/batch", recoveryMiddleware(authMiddleware(batchTodos))).Methods("POST")
+	api.HandleFunc("/todos", recoveryMiddleware(authMiddleware(addTodo))).Methods("POST")
+	api.HandleFunc("/todos/{id}", recoveryMiddleware(authMiddleware(deleteTodo))).Methods("DELETE")
+	api.HandleFunc("/todos/{id}/complete", recoveryMiddleware(authMiddleware(completeTodo))).Methods("PUT")
+	api.HandleFunc("/todos/{id}/comments", recoveryMiddleware(authMiddleware(getComments))).Methods("GET")
+	api.HandleFunc("/todos/{id}/comments", recoveryMiddleware(authMiddleware(addComment))).Methods("POST")
+
+	// Handle CORS preflight requests
+	api.HandleFunc("/login", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/logout", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/me", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/update-password", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/me/notifications", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/password-reset/{step}", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/login/2fa", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/2fa/{action}", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/users/{id}/2fa", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/roles", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/roles/{name}", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/users", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/users/{id}", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/todos", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/todos/{id}", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/todos/{id}/restore", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/todos/{id}/comments", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/todos/trash/{id}", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/users/{id}/restore", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/users/trash/{id}", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/webhooks", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/webhooks/{id}", handleOptions).Methods("OPTIONS")
+	api.HandleFunc("/admin/webhooks/deliveries/{id}/redeliver", handleOptions).Methods("OPTIONS")
+}
+
 func main() {
-	// Add panic recovery
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Printf("Server panic recovered: %v\n", r)
-		}
-	}()
+	slog.SetDefault(loadLogger())
+	loadTracing()
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		slog.Error("Loading TLS certificate failed", "error", err)
+		os.Exit(1)
+	}
 
-	// Initialize with sample data for different users
+	// Initialize with sample data for different users. The sample todos name
+	// their users, like data from before todos held user IDs, and are linked by
+	// migrateTodoUsers below.
 	now := time.Now()
 	todos = []Todo{
-		{ID: 1, Text: "Review code changes", Completed: false, User: "alice", CreatedAt: now.Add(-2 * time.Hour).Format("2006-01-02 15:04:05")},
-		{ID: 2, Text: "Update documentation", Completed: true, User: "bob", CreatedAt: now.Add(-1 * time.Hour).Format("2006-01-02 15:04:05")},
-		{ID: 3, Text: "Fix bug in login", Completed: false, User: "alice", CreatedAt: now.Add(-50 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 4, Text: "Deploy to staging", Completed: false, User: "charlie", CreatedAt: now.Add(-45 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 5, Text: "Write unit tests", Completed: true, User: "alice", CreatedAt: now.Add(-40 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 6, Text: "Design new feature", Completed: false, User: "bob", CreatedAt: now.Add(-35 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 7, Text: "Code review for PR #123", Completed: true, User: "charlie", CreatedAt: now.Add(-30 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 8, Text: "Update API documentation", Completed: false, User: "alice", CreatedAt: now.Add(-25 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 9, Text: "Fix database migration", Completed: true, User: "bob", CreatedAt: now.Add(-20 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 10, Text: "Implement user authentication", Completed: false, User: "charlie", CreatedAt: now.Add(-15 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 11, Text: "Optimize database queries", Completed: true, User: "alice", CreatedAt: now.Add(-10 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 12, Text: "Add error handling", Completed: false, User: "bob", CreatedAt: now.Add(-5 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 13, Text: "Update dependencies", Completed: true, User: "charlie", CreatedAt: now.Add(-3 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 14, Text: "Create user interface mockups", Completed: false, User: "alice", CreatedAt: now.Add(-1 * time.Minute).Format("2006-01-02 15:04:05")},
-		{ID: 15, Text: "Set up CI/CD pipeline", Completed: true, User: "bob", CreatedAt: now.Add(-30 * time.Second).Format("2006-01-02 15:04:05")},
+		{ID: 1, Text: "Review code changes", Completed: false, User: "alice", CreatedAt: now.Add(-2 * time.Hour).Format(timestampFormat)},
+		{ID: 2, Text: "Update documentation", Completed: true, User: "bob", CreatedAt: now.Add(-1 * time.Hour).Format(timestampFormat)},
+		{ID: 3, Text: "Fix bug in login", Completed: false, User: "alice", CreatedAt: now.Add(-50 * time.Minute).Format(timestampFormat)},
+		{ID: 4, Text: "Deploy to staging", Completed: false, User: "charlie", CreatedAt: now.Add(-45 * time.Minute).Format(timestampFormat)},
+		{ID: 5, Text: "Write unit tests", Completed: true, User: "alice", CreatedAt: now.Add(-40 * time.Minute).Format(timestampFormat)},
+		{ID: 6, Text: "Design new feature", Completed: false, User: "bob", CreatedAt: now.Add(-35 * time.Minute).Format(timestampFormat)},
+		{ID: 7, Text: "Code review for PR #123", Completed: true, User: "charlie", CreatedAt: now.Add(-30 * time.Minute).Format(timestampFormat)},
+		{ID: 8, Text: "Update API documentation", Completed: false, User: "alice", CreatedAt: now.Add(-25 * time.Minute).Format(timestampFormat)},
+		{ID: 9, Text: "Fix database migration", Completed: true, User: "bob", CreatedAt: now.Add(-20 * time.Minute).Format(timestampFormat)},
+		{ID: 10, Text: "Implement user authentication", Completed: false, User: "charlie", CreatedAt: now.Add(-15 * time.Minute).Format(timestampFormat)},
+		{ID: 11, Text: "Optimize database queries", Completed: true, User: "alice", CreatedAt: now.Add(-10 * time.Minute).Format(timestampFormat)},
+		{ID: 12, Text: "Add error handling", Completed: false, User: "bob", CreatedAt: now.Add(-5 * time.Minute).Format(timestampFormat)},
+		{ID: 13, Text: "Update dependencies", Completed: true, User: "charlie", CreatedAt: now.Add(-3 * time.Minute).Format(timestampFormat)},
+		{ID: 14, Text: "Create user interface mockups", Completed: false, User: "alice", CreatedAt: now.Add(-1 * time.Minute).Format(timestampFormat)},
+		{ID: 15, Text: "Set up CI/CD pipeline", Completed: true, User: "bob", CreatedAt: now.Add(-30 * time.Second).Format(timestampFormat)},
 	}
 	nextID = 16
 
@@ -640,45 +1009,48 @@ func main() {
 	}
 	nextUserID = 5
 
-	r := mux.NewRouter()
-
-	// Authentication routes
-	r.HandleFunc("/login", recoveryMiddleware(login)).Methods("POST")
-	r.HandleFunc("/logout", recoveryMiddleware(logout)).Methods("POST")
-	r.HandleFunc("/me", recoveryMiddleware(getCurrentUser)).Methods("GET")
-	r.HandleFunc("/update-password", recoveryMiddleware(authMiddleware(updatePassword))).Methods("POST")
-
-	// User management routes
-	r.HandleFunc("/admin/users", authMiddleware(getUsers)).Methods("GET")                            // All authenticated users can see users
-	r.HandleFunc("/admin/users", authMiddleware(adminMiddleware(createUser))).Methods("POST")        // Only admin can create
-	r.HandleFunc("/admin/users/{id}", authMiddleware(adminMiddleware(updateUser))).Methods("PUT")    // Only admin can update
-	r.HandleFunc("/admin/users/{id}", authMiddleware(adminMiddleware(deleteUser))).Methods("DELETE") // Only admin can delete
-
-	// Todo routes (authenticated users)
-	r.HandleFunc("/todos", recoveryMiddleware(authMiddleware(getTodos))).Methods("GET")
-	r.HandleFunc("/todos", recoveryMiddleware(authMiddleware(addTodo))).Methods("POST")
-	r.HandleFunc("/todos/{id}", recoveryMiddleware(authMiddleware(deleteTodo))).Methods("DELETE")
-	r.HandleFunc("/todos/{id}/complete", recoveryMiddleware(authMiddleware(completeTodo))).Methods("PUT")
+	if err := migrateTodoUsers(); err != nil {
+		slog.Error("Linking todos to their users failed", "error", err)
+		os.Exit(1)
+	}
+	if err := loadRoles(); err != nil {
+		slog.Error("Loading roles failed", "error", err)
+		os.Exit(1)
+	}
+	storeLoaded.Store(true)
+	passwordPolicy = loadPasswordPolicy()
+	trashRetention = loadTrashRetention()
+	notifier = loadNotifier()
+	if err := loadNotificationTemplates(); err != nil {
+		slog.Error("Loading notification templates failed", "error", err)
+		os.Exit(1)
+	}
+	loadNotificationSchedule()
+	shutdownTimeout = loadShutdownTimeout()
+	loadRateLimits()
+	startNotificationScheduler()
+	startWebhookWorkers()
+
+	// Enable single sign-on when an OIDC issuer is configured
+	if config, ok := loadOIDCConfig(); ok {
+		oidcProvider = NewOIDCProvider(config)
+		slog.Info("Single sign-on enabled", "issuer", config.Issuer)
+	}
 
-	// Handle CORS preflight requests
-	r.HandleFunc("/login", handleOptions).Methods("OPTIONS")
-	r.HandleFunc("/logout", handleOptions).Methods("OPTIONS")
-	r.HandleFunc("/me", handleOptions).Methods("OPTIONS")
-	r.HandleFunc("/update-password", handleOptions).Methods("OPTIONS")
-	r.HandleFunc("/admin/users", handleOptions).Methods("OPTIONS")
-	r.HandleFunc("/admin/users/{id}", handleOptions).Methods("OPTIONS")
-	r.HandleFunc("/todos", handleOptions).Methods("OPTIONS")
-	r.HandleFunc("/todos/{id}", handleOptions).Methods("OPTIONS")
+	r := newRouter()
 
-	// Serve index.html as root
-	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "index.html")
-	})
+	servers := []*http.Server{newServer(httpAddr, r)}
+	if tlsConfig != nil {
+		https := newServer(httpsAddr, r)
+		https.TLSConfig = tlsConfig
+		servers = []*http.Server{https, newServer(httpAddr, http.HandlerFunc(redirectToHTTPS))}
+	}
 
-	fmt.Println("🚀 Team To-Do App Server Starting...")
-	fmt.Println("📍 Server: http://localhost:8080")
-	fmt.Println("👤 Admin: username=admin, password=admin")
-	fmt.Println("👤 Users: username=alice/bob/charlie, password=password123")
+	slog.Info("Team To-Do App server starting", "url", serverBaseURL)
+	slog.Info("Demo accounts", "admin", "admin/admin", "users", "alice/bob/charlie with password123")
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	if err := serve(servers...); err != nil {
+		slog.Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Swap in the demo users and no todos or comments for the length of a test,
+// restoring the store afterwards
+func useTestData(t *testing.T) {
+	t.Helper()
+	savedTodos, savedUsers, savedNextID, savedNextUserID := todos, users, nextID, nextUserID
+	savedComments, savedNextCommentID := comments, nextCommentID
+	t.Cleanup(func() {
+		todos, users, nextID, nextUserID = savedTodos, savedUsers, savedNextID, savedNextUserID
+		comments, nextCommentID = savedComments, savedNextCommentID
+	})
+
+	todos, comments = nil, nil
+	users = []User{
+		{ID: 1, Username: "admin", Password: "admin", Role: "admin"},
+		{ID: 2, Username: "alice", Password: "password123", Role: "user"},
+		{ID: 3, Username: "bob", Password: "password123", Role: "user"},
+		{ID: 4, Username: "charlie", Password: "password123", Role: "user"},
+	}
+	nextID, nextUserID, nextCommentID = 1, 5, 1
+}
+
+// Send a request with a JSON body and the given session cookies through the handler
+func doJSON(t *testing.T, handler http.Handler, method, target, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// Log in with a password and return the session cookies
+func logIn(t *testing.T, handler http.Handler, username, password string) []*http.Cookie {
+	t.Helper()
+	rec := doJSON(t, handler, http.MethodPost, "/api/v1/login", `{"username":"`+username+`","password":"`+password+`"}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login as %s: status %d: %s", username, rec.Code, rec.Body)
+	}
+	return rec.Result().Cookies()
+}
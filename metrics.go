@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+
+	"to-do-list/api"
+)
+
+// Upper bounds of the request latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestLabels struct {
+	method, route, status string
+}
+
+type loginLabels struct {
+	method, result string
+}
+
+type histogram struct {
+	buckets []uint64 // Cumulative counts per latencyBuckets entry
+	sum     float64
+	count   uint64
+}
+
+// Request and login metrics are recorded outside the data lock, so they have their own
+var (
+	metricsMu        sync.Mutex
+	httpRequests     = make(map[requestLabels]uint64)
+	httpDurations    = make(map[requestLabels]*histogram)
+	loginAttempts    = make(map[loginLabels]uint64)
+	rateLimited      = make(map[string]uint64)
+	metricsStartTime = time.Now()
+)
+
+// A logged-in session, keyed by the session_id stored in its cookie
+type trackedSession struct {
+	userID   int
+	lastSeen time.Time
+}
+
+var (
+	sessionsMu     sync.Mutex
+	activeSessions = make(map[string]*trackedSession)
+)
+
+// Count a finished request for /metrics
+func observeRequest(method, route string, status int, latency time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	labels := requestLabels{method, route, strconv.Itoa(status)}
+	seconds := latency.Seconds()
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	httpRequests[labels]++
+	h := httpDurations[labels]
+	if h == nil {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		httpDurations[labels] = h
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Count a login attempt. method is "password", "two_factor" or "oidc".
+func recordLogin(method string, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	metricsMu.Lock()
+	loginAttempts[loginLabels{method, result}]++
+	metricsMu.Unlock()
+}
+
+// Count a request turned away by rateLimitMiddleware
+func recordRateLimited(class string) {
+	metricsMu.Lock()
+	rateLimited[class]++
+	metricsMu.Unlock()
+}
+
+// Give a newly logged-in session an ID so it can be counted until it logs out or expires
+func trackSession(session *sessions.Session, user *User) {
+	id := randomToken(16)
+	session.Values["session_id"] = id
+	sessionsMu.Lock()
+	activeSessions[id] = &trackedSession{userID: user.ID, lastSeen: time.Now()}
+	sessionsMu.Unlock()
+}
+
+// Note that a session was used. Sessions from before a restart are picked up again here.
+func touchSession(session *sessions.Session, user *User) {
+	id, ok := session.Values["session_id"].(string)
+	if !ok {
+		return
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if tracked := activeSessions[id]; tracked != nil {
+		tracked.lastSeen = time.Now()
+		return
+	}
+	activeSessions[id] = &trackedSession{userID: user.ID, lastSeen: time.Now()}
+}
+
+// Stop counting all of a user's sessions, after they have been revoked
+func untrackUserSessions(userID int) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for id, tracked := range activeSessions {
+		if tracked.userID == userID {
+			delete(activeSessions, id)
+		}
+	}
+}
+
+func untrackSession(session *sessions.Session) {
+	if id, ok := session.Values["session_id"].(string); ok {
+		sessionsMu.Lock()
+		delete(activeSessions, id)
+		sessionsMu.Unlock()
+	}
+}
+
+// GET /metrics — Prometheus metrics. When METRICS_TOKEN is set the scraper must
+// send it as a bearer token.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if token := os.Getenv("METRICS_TOKEN"); token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Metrics token required")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeRequestMetrics(w)
+	writeSessionMetrics(w)
+	writeTodoMetrics(w)
+}
+
+func writeRequestMetrics(w io.Writer) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	requests := make([]requestLabels, 0, len(httpRequests))
+	for labels := range httpRequests {
+		requests = append(requests, labels)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	writeMetricHeader(w, "http_requests_total", "counter", "HTTP requests by method, route template and status.")
+	for _, l := range requests {
+		fmt.Fprintf(w, "http_requests_total{%s} %d\n", formatLabels("method", l.method, "route", l.route, "status", l.status), httpRequests[l])
+	}
+
+	writeMetricHeader(w, "http_request_duration_seconds", "histogram", "HTTP request latency by method, route template and status.")
+	for _, l := range requests {
+		h := httpDurations[l]
+		labels := formatLabels("method", l.method, "route", l.route, "status", l.status)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), h.buckets[i])
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeMetricHeader(w, "todo_logins_total", "counter", "Login attempts by method and result.")
+	for _, method := range []string{"password", "two_factor", "oidc"} {
+		for _, result := range []string{"success", "failure"} {
+			fmt.Fprintf(w, "todo_logins_total{%s} %d\n", formatLabels("method", method, "result", result), loginAttempts[loginLabels{method, result}])
+		}
+	}
+
+	writeMetricHeader(w, "todo_rate_limited_total", "counter", "Requests rejected with 429 by rate limit class.")
+	for _, class := range rateLimitClasses {
+		fmt.Fprintf(w, "todo_rate_limited_total{%s} %d\n", formatLabels("class", class), rateLimited[class])
+	}
+
+	writeMetricHeader(w, "process_start_time_seconds", "gauge", "Start time of the server since the Unix epoch, in seconds.")
+	fmt.Fprintf(w, "process_start_time_seconds %d\n", metricsStartTime.Unix())
+}
+
+// Sessions that have logged out, expired or whose user is gone are dropped here
+func writeSessionMetrics(w io.Writer) {
+	lifetime := time.Duration(store.Options.MaxAge) * time.Second
+	activeUsers := make(map[int]bool)
+	dataMu.RLock()
+	sessionsMu.Lock()
+	for id, tracked := range activeSessions {
+		user := findUserByID(context.Background(), tracked.userID)
+		if time.Since(tracked.lastSeen) > lifetime || user == nil || user.DeletedAt != "" {
+			delete(activeSessions, id)
+			continue
+		}
+		activeUsers[tracked.userID] = true
+	}
+	sessionCount := len(activeSessions)
+	sessionsMu.Unlock()
+	dataMu.RUnlock()
+
+	writeMetricHeader(w, "todo_active_sessions", "gauge", "Logged-in sessions that have not logged out or expired.")
+	fmt.Fprintf(w, "todo_active_sessions %d\n", sessionCount)
+	writeMetricHeader(w, "todo_active_users", "gauge", "Users with at least one active session.")
+	fmt.Fprintf(w, "todo_active_users %d\n", len(activeUsers))
+}
+
+// Todo counts per assignee, computed from the store; trashed todos are left out
+func writeTodoMetrics(w io.Writer) {
+	type counts struct{ total, pending, completed int }
+	byUser := make(map[string]*counts)
+	activeUsers := 0
+	dataMu.RLock()
+	for _, u := range users {
+		if u.DeletedAt == "" {
+			byUser[u.Username] = &counts{}
+			activeUsers++
+		}
+	}
+	for _, t := range todos {
+		if t.DeletedAt != "" {
+			continue
+		}
+		c := byUser[t.User]
+		if c == nil {
+			c = &counts{}
+			byUser[t.User] = c
+		}
+		c.total++
+		if t.Completed {
+			c.completed++
+		} else {
+			c.pending++
+		}
+	}
+	dataMu.RUnlock()
+
+	names := make([]string, 0, len(byUser))
+	for name := range byUser {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeMetricHeader(w, "todo_todos", "gauge", "Todos assigned to each user.")
+	for _, name := range names {
+		fmt.Fprintf(w, "todo_todos{%s} %d\n", formatLabels("user", name), byUser[name].total)
+	}
+	writeMetricHeader(w, "todo_todos_pending", "gauge", "Open todos assigned to each user.")
+	for _, name := range names {
+		fmt.Fprintf(w, "todo_todos_pending{%s} %d\n", formatLabels("user", name), byUser[name].pending)
+	}
+	writeMetricHeader(w, "todo_todos_completed", "gauge", "Completed todos assigned to each user.")
+	for _, name := range names {
+		fmt.Fprintf(w, "todo_todos_completed{%s} %d\n", formatLabels("user", name), byUser[name].completed)
+	}
+	writeMetricHeader(w, "todo_users", "gauge", "Users that are not in the trash.")
+	fmt.Fprintf(w, "todo_users %d\n", activeUsers)
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Format name/value pairs as name="value",...
+func formatLabels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"to-do-list/api"
+)
+
+// Kinds of notification sent to users
+const (
+	NotifyAssigned    = "assigned"
+	NotifyCompleted   = "completed"
+	NotifyComment     = "comment"
+	NotifyDueReminder = "due_reminder"
+	notifyDigest      = "digest"
+)
+
+// How a user's notifications are delivered
+const (
+	NotifyImmediately = "immediate"
+	NotifyDailyDigest = "daily_digest"
+)
+
+// Layout for todo due dates
+const dueDateFormat = "2006-01-02"
+
+type NotificationPreferences struct {
+	Assigned     bool   `json:"assigned"`
+	Completed    bool   `json:"completed"`
+	Comments     bool   `json:"comments"`
+	DueReminders bool   `json:"due_reminders"`
+	Delivery     string `json:"delivery"`
+}
+
+type NotificationPreferencesRequest struct {
+	Assigned     *bool   `json:"assigned"`
+	Completed    *bool   `json:"completed"`
+	Comments     *bool   `json:"comments"`
+	DueReminders *bool   `json:"due_reminders"`
+	Delivery     *string `json:"delivery"`
+}
+
+func defaultNotificationPreferences() NotificationPreferences {
+	return NotificationPreferences{
+		Assigned:     true,
+		Completed:    true,
+		Comments:     true,
+		DueReminders: true,
+		Delivery:     NotifyImmediately,
+	}
+}
+
+// Users who never changed their preferences get the defaults
+func (u *User) notificationPreferences() NotificationPreferences {
+	if u.Notifications == nil {
+		return defaultNotificationPreferences()
+	}
+	return *u.Notifications
+}
+
+func (p NotificationPreferences) wants(kind string) bool {
+	switch kind {
+	case NotifyAssigned:
+		return p.Assigned
+	case NotifyCompleted:
+		return p.Completed
+	case NotifyComment:
+		return p.Comments
+	case NotifyDueReminder:
+		return p.DueReminders
+	}
+	return false
+}
+
+// Address notifications are sent to: the email when known, otherwise the username
+func notificationAddress(user *User) string {
+	if user.Email != "" {
+		return user.Email
+	}
+	return user.Username
+}
+
+// The active user with this username, regardless of case
+func findUserByUsername(ctx context.Context, username string) *User {
+	defer startStoreSpan(ctx, "findUserByUsername").finish()
+	for i, u := range users {
+		if sameUsername(u.Username, username) && u.DeletedAt == "" {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// Values available to notification templates
+type notificationData struct {
+	Recipient string
+	Actor     string
+	Todo      Todo
+	Comment   string
+	Link      string
+	Items     []Notification // Messages collected for a digest
+}
+
+type messageTemplate struct {
+	Subject *template.Template
+	Body    *template.Template
+}
+
+func newMessageTemplate(name, subject, body string) messageTemplate {
+	return messageTemplate{
+		Subject: template.Must(template.New(name + ".subject").Parse(subject)),
+		Body:    template.Must(template.New(name + ".body").Parse(body)),
+	}
+}
+
+var notificationTemplates = map[string]messageTemplate{
+	NotifyAssigned: newMessageTemplate(NotifyAssigned,
+		`{{.Actor}} assigned you "{{.Todo.Text}}"`,
+		`Hi {{.Recipient}},
+
+{{.Actor}} assigned you a new todo:
+
+  {{.Todo.Text}}{{if .Todo.DueDate}} (due {{.Todo.DueDate}}){{end}}
+
+{{.Link}}
+`),
+	NotifyCompleted: newMessageTemplate(NotifyCompleted,
+		`{{.Actor}} completed "{{.Todo.Text}}"`,
+		`Hi {{.Recipient}},
+
+{{.Actor}} marked this todo as completed:
+
+  {{.Todo.Text}}
+
+{{.Link}}
+`),
+	NotifyComment: newMessageTemplate(NotifyComment,
+		`{{.Actor}} commented on "{{.Todo.Text}}"`,
+		`Hi {{.Recipient}},
+
+{{.Actor}} commented on "{{.Todo.Text}}":
+
+  {{.Comment}}
+
+{{.Link}}
+`),
+	NotifyDueReminder: newMessageTemplate(NotifyDueReminder,
+		`Reminder: "{{.Todo.Text}}" is due {{.Todo.DueDate}}`,
+		`Hi {{.Recipient}},
+
+This todo is due on {{.Todo.DueDate}} and is still open:
+
+  {{.Todo.Text}}
+
+{{.Link}}
+`),
+	notifyDigest: newMessageTemplate(notifyDigest,
+		`Your Team To-Do digest: {{len .Items}} update{{if ne (len .Items) 1}}s{{end}}`,
+		`Hi {{.Recipient}},
+
+Here is what happened since your last digest:
+{{range .Items}}
+- {{.Subject}}{{end}}
+
+{{.Link}}
+`),
+}
+
+// Replace built-in templates with files from NOTIFY_TEMPLATES_DIR, named
+// <kind>.subject.tmpl and <kind>.body.tmpl, e.g. assigned.body.tmpl
+func loadNotificationTemplates() error {
+	dir := os.Getenv("NOTIFY_TEMPLATES_DIR")
+	if dir == "" {
+		return nil
+	}
+
+	for kind, tmpl := range notificationTemplates {
+		for _, part := range []string{"subject", "body"} {
+			path := filepath.Join(dir, kind+"."+part+".tmpl")
+			data, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			parsed, err := template.New(kind + "." + part).Parse(string(data))
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if part == "subject" {
+				tmpl.Subject = parsed
+			} else {
+				tmpl.Body = parsed
+			}
+		}
+		notificationTemplates[kind] = tmpl
+	}
+	return nil
+}
+
+func renderNotification(kind string, data notificationData) (Notification, error) {
+	tmpl, ok := notificationTemplates[kind]
+	if !ok {
+		return Notification{}, fmt.Errorf("no template for %q", kind)
+	}
+	var subject, body bytes.Buffer
+	if err := tmpl.Subject.Execute(&subject, data); err != nil {
+		return Notification{}, err
+	}
+	if err := tmpl.Body.Execute(&body, data); err != nil {
+		return Notification{}, err
+	}
+	// Subjects are a single header line
+	return Notification{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Body:    body.String(),
+	}, nil
+}
+
+// Send in the background so a slow mail server never holds up a request
+func sendNotification(n Notification) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		if err := notifier.Notify(n); err != nil {
+			slog.Error("Notification failed", "to", n.To, "error", err)
+		}
+	}()
+}
+
+var (
+	reminderLead = 24 * time.Hour // How long before the end of the due date reminders go out
+	digestHour   = 8              // Local hour at which daily digests are sent
+
+	// Messages waiting for the next digest, by user ID. Guarded by dataMu.
+	pendingDigests = make(map[int][]Notification)
+	lastDigestDate string
+
+	// Todos whose due reminder has gone out, by todo ID. Guarded by dataMu.
+	remindersSent = make(map[int]bool)
+)
+
+// Load reminder and digest settings from REMINDER_LEAD and DIGEST_HOUR
+func loadNotificationSchedule() {
+	if value := os.Getenv("REMINDER_LEAD"); value != "" {
+		lead, err := time.ParseDuration(value)
+		if err != nil || lead < 0 {
+			slog.Warn("Invalid REMINDER_LEAD", "value", value, "using", reminderLead)
+		} else {
+			reminderLead = lead
+		}
+	}
+	if value := os.Getenv("DIGEST_HOUR"); value != "" {
+		hour, err := strconv.Atoi(value)
+		if err != nil || hour < 0 || hour > 23 {
+			slog.Warn("Invalid DIGEST_HOUR", "value", value, "using", digestHour)
+		} else {
+			digestHour = hour
+		}
+	}
+}
+
+// Notify a user about a todo, honouring their preferences. The actor never
+// hears about their own actions. Must be called with dataMu held.
+func notifyUser(userID int, kind, actor string, data notificationData) {
+	user := findUserByID(context.Background(), userID)
+	if user == nil || user.Username == actor {
+		return
+	}
+	prefs := user.notificationPreferences()
+	if !prefs.wants(kind) {
+		return
+	}
+
+	data.Recipient = user.Username
+	data.Actor = actor
+	data.Link = appBaseURL() + "/"
+	msg, err := renderNotification(kind, data)
+	if err != nil {
+		slog.Error("Rendering notification failed", "kind", kind, "error", err)
+		return
+	}
+	msg.To = notificationAddress(user)
+
+	if prefs.Delivery == NotifyDailyDigest {
+		pendingDigests[user.ID] = append(pendingDigests[user.ID], msg)
+		return
+	}
+	sendNotification(msg)
+}
+
+// Run the due reminder and digest jobs once a minute
+func startNotificationScheduler() {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				dataMu.Lock()
+				sendDueReminders(now)
+				sendDigests(now)
+				dataMu.Unlock()
+			case <-stopWorkers:
+				return
+			}
+		}
+	}()
+}
+
+// Remind owners of open todos whose due date ends within reminderLead. Each
+// todo is only reminded about once.
+func sendDueReminders(now time.Time) {
+	for _, todo := range todos {
+		if todo.DueDate == "" || todo.Completed || todo.DeletedAt != "" || remindersSent[todo.ID] {
+			continue
+		}
+		due, err := time.ParseInLocation(dueDateFormat, todo.DueDate, time.Local)
+		if err != nil {
+			continue
+		}
+		// Due dates last until the end of the day
+		if now.Before(due.AddDate(0, 0, 1).Add(-reminderLead)) {
+			continue
+		}
+		remindersSent[todo.ID] = true
+		notifyUser(todo.UserID, NotifyDueReminder, "", notificationData{Todo: todo})
+	}
+}
+
+// Send each user's collected messages as one digest, once a day after digestHour
+func sendDigests(now time.Time) {
+	today := now.Format(dueDateFormat)
+	if now.Hour() < digestHour || lastDigestDate == today {
+		return
+	}
+	lastDigestDate = today
+
+	for userID, items := range pendingDigests {
+		delete(pendingDigests, userID)
+		user := findUserByID(context.Background(), userID)
+		if user == nil || len(items) == 0 {
+			continue
+		}
+		msg, err := renderNotification(notifyDigest, notificationData{
+			Recipient: user.Username,
+			Items:     items,
+			Link:      appBaseURL() + "/",
+		})
+		if err != nil {
+			slog.Error("Rendering digest failed", "error", err)
+			continue
+		}
+		msg.To = notificationAddress(user)
+		sendNotification(msg)
+	}
+}
+
+// GET /me/notifications — The caller's notification preferences
+func getNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	dataMu.RLock()
+	_, user, ok := currentSessionUser(w, r)
+	var prefs NotificationPreferences
+	if ok {
+		prefs = user.notificationPreferences()
+	}
+	dataMu.RUnlock()
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(w).Encode(prefs)
+}
+
+// PUT /me/notifications — Change the caller's notification preferences
+func updateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req NotificationPreferencesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	_, user, ok := currentSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Only change the fields that were provided
+	prefs := user.notificationPreferences()
+	if req.Assigned != nil {
+		prefs.Assigned = *req.Assigned
+	}
+	if req.Completed != nil {
+		prefs.Completed = *req.Completed
+	}
+	if req.Comments != nil {
+		prefs.Comments = *req.Comments
+	}
+	if req.DueReminders != nil {
+		prefs.DueReminders = *req.DueReminders
+	}
+	if req.Delivery != nil {
+		if *req.Delivery != NotifyImmediately && *req.Delivery != NotifyDailyDigest {
+			msg := fmt.Sprintf("Delivery must be %s or %s", NotifyImmediately, NotifyDailyDigest)
+			writeError(w, r, http.StatusBadRequest, api.CodeValidationFailed, msg)
+			return
+		}
+		prefs.Delivery = *req.Delivery
+	}
+	user.Notifications = &prefs
+
+	json.NewEncoder(w).Encode(prefs)
+}
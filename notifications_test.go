@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"to-do-list/api"
+	"to-do-list/client"
+)
+
+// A message as the SMTP sink received it
+type sentMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// An in-process mail server that accepts everything and hands each message
+// to the test. It offers no STARTTLS and no AUTH, so mail goes out in plain text.
+type smtpSink struct {
+	host, port string
+	mail       chan sentMail
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	s := &smtpSink{host: host, port: port, mail: make(chan sentMail, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 sink ready")
+	var msg sentMail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 sink")
+		case "MAIL":
+			msg = sentMail{From: strings.Trim(strings.TrimPrefix(line[len("MAIL FROM:"):], " "), "<>")}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, strings.Trim(strings.TrimPrefix(line[len("RCPT TO:"):], " "), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := readData(tp.Reader.R)
+			if err != nil {
+				return
+			}
+			msg.Data = data
+			s.mail <- msg
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+// Read a DATA section up to the lone "." line, keeping the CRLF line endings
+func readData(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line == ".\r\n" {
+			return b.String(), nil
+		}
+		b.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+// The next message, failing the test if none arrives in time
+func (s *smtpSink) next(t *testing.T) sentMail {
+	t.Helper()
+	select {
+	case msg := <-s.mail:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail arrived")
+		return sentMail{}
+	}
+}
+
+// Send notifications to the sink for the length of a test
+func useSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	sink := newSMTPSink(t)
+	saved := notifier
+	notifier = NewSMTPNotifier(sink.host, sink.port, "", "", "todo@example.com")
+	t.Cleanup(func() {
+		workers.Wait()
+		notifier = saved
+	})
+	return sink
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	sink := newSMTPSink(t)
+	n := NewSMTPNotifier(sink.host, sink.port, "", "", "todo@example.com")
+
+	err := n.Notify(Notification{
+		To:      "alice@example.com",
+		Subject: "Café\r\nBcc: mallory@example.com",
+		Body:    "First line\nSecond line\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := sink.next(t)
+	if msg.From != "todo@example.com" || len(msg.To) != 1 || msg.To[0] != "alice@example.com" {
+		t.Errorf("envelope from %q to %q", msg.From, msg.To)
+	}
+	for _, want := range []string{
+		"From: todo@example.com\r\n",
+		"To: alice@example.com\r\n",
+		"Subject: =?utf-8?q?Caf=C3=A9Bcc:_mallory@example.com?=\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nFirst line\r\nSecond line\r\n",
+	} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("message is missing %q:\n%s", want, msg.Data)
+		}
+	}
+	if strings.Contains(msg.Data, "\r\nBcc:") {
+		t.Errorf("subject broke onto its own header line:\n%s", msg.Data)
+	}
+
+	if err := n.Notify(Notification{To: "alice", Subject: "Hi", Body: "Hi"}); err == nil {
+		t.Error("sending to a username without an email address succeeded")
+	}
+}
+
+// Create the demo users' email addresses and log in as admin and alice
+func notificationClients(t *testing.T, url string) (admin, alice *client.Client) {
+	t.Helper()
+	for i := range users {
+		users[i].Email = users[i].Username + "@example.com"
+	}
+	ctx := context.Background()
+	admin, _ = client.New(url)
+	if _, err := admin.Login(ctx, "admin", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	alice, _ = client.New(url)
+	if _, err := alice.Login(ctx, "alice", "password123"); err != nil {
+		t.Fatal(err)
+	}
+	return admin, alice
+}
+
+func TestNotificationTriggersSendMail(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	sink := useSMTPSink(t)
+	srv := httptest.NewServer(newRouter())
+	defer srv.Close()
+	ctx := context.Background()
+	admin, alice := notificationClients(t, srv.URL)
+
+	todo, err := admin.CreateTodo(ctx, api.NewTodo{Text: "Renew the lease", User: "alice", DueDate: "2030-01-31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := sink.next(t)
+	if msg.To[0] != "alice@example.com" || !strings.Contains(msg.Data, "Subject: admin assigned you \"Renew the lease\"\r\n") ||
+		!strings.Contains(msg.Data, "Renew the lease (due 2030-01-31)") {
+		t.Errorf("assignment mail to %v:\n%s", msg.To, msg.Data)
+	}
+
+	// Comments go to the assignee, but never to the commenter
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+api.Prefix+"/todos/"+strconv.Itoa(todo.ID)+"/comments", strings.NewReader(`{"text":"Call the landlord first"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: api.SessionCookie, Value: admin.Token()})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("comment: status %d", resp.StatusCode)
+	}
+	msg = sink.next(t)
+	if msg.To[0] != "alice@example.com" || !strings.Contains(msg.Data, "Call the landlord first") {
+		t.Errorf("comment mail to %v:\n%s", msg.To, msg.Data)
+	}
+
+	// Completing tells the creator
+	if err := alice.CompleteTodo(ctx, todo.ID); err != nil {
+		t.Fatal(err)
+	}
+	msg = sink.next(t)
+	if msg.To[0] != "admin@example.com" || !strings.Contains(msg.Data, "Subject: alice completed \"Renew the lease\"\r\n") {
+		t.Errorf("completion mail to %v:\n%s", msg.To, msg.Data)
+	}
+
+	// Due reminders go out once
+	dataMu.Lock()
+	due, _ := time.ParseInLocation(dueDateFormat, "2030-01-31", time.Local)
+	todos[0].Completed = false
+	sendDueReminders(due)
+	sendDueReminders(due)
+	delete(remindersSent, todo.ID)
+	dataMu.Unlock()
+	msg = sink.next(t)
+	if msg.To[0] != "alice@example.com" || !strings.Contains(msg.Data, "Subject: Reminder: \"Renew the lease\" is due 2030-01-31\r\n") {
+		t.Errorf("reminder mail to %v:\n%s", msg.To, msg.Data)
+	}
+	workers.Wait()
+	select {
+	case extra := <-sink.mail:
+		t.Errorf("unexpected mail to %v:\n%s", extra.To, extra.Data)
+	default:
+	}
+}
+
+func TestDailyDigest(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	sink := useSMTPSink(t)
+	savedLastDigest := lastDigestDate
+	t.Cleanup(func() { lastDigestDate = savedLastDigest })
+	srv := httptest.NewServer(newRouter())
+	defer srv.Close()
+	ctx := context.Background()
+	admin, _ := notificationClients(t, srv.URL)
+
+	dataMu.Lock()
+	findUserByUsername(ctx, "alice").Notifications = &NotificationPreferences{Assigned: true, Delivery: NotifyDailyDigest}
+	dataMu.Unlock()
+	for _, text := range []string{"Book the venue", "Order the cake"} {
+		if _, err := admin.CreateTodo(ctx, api.NewTodo{Text: text, User: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataMu.Lock()
+	lastDigestDate = ""
+	morning := time.Date(2030, 1, 15, digestHour, 0, 0, 0, time.Local)
+	sendDigests(morning.Add(-time.Minute)) // Too early
+	sendDigests(morning)
+	sendDigests(morning.Add(time.Hour)) // Already sent today
+	dataMu.Unlock()
+
+	msg := sink.next(t)
+	if msg.To[0] != "alice@example.com" || !strings.Contains(msg.Data, "Subject: Your Team To-Do digest: 2 updates\r\n") {
+		t.Errorf("digest to %v:\n%s", msg.To, msg.Data)
+	}
+	for _, item := range []string{"- admin assigned you \"Book the venue\"", "- admin assigned you \"Order the cake\""} {
+		if !strings.Contains(msg.Data, item) {
+			t.Errorf("digest is missing %q:\n%s", item, msg.Data)
+		}
+	}
+	workers.Wait()
+	select {
+	case extra := <-sink.mail:
+		t.Errorf("unexpected mail to %v:\n%s", extra.To, extra.Data)
+	default:
+	}
+}
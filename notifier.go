@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification is a message for a single recipient
+type Notification struct {
+	To      string
+	Subject string
+	Body    string
+	Secret  bool // The body holds a credential, such as a reset link, that must not be logged
+}
+
+// Notifier delivers notifications to users
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// FileNotifier appends notifications to a file instead of sending them,
+// which is enough to pick up reset links during development
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(msg Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format("2006-01-02 15:04:05"), msg.To, msg.Subject, strings.TrimSpace(msg.Body))
+	return err
+}
+
+// StdoutNotifier prints notifications to the server console. Bodies holding
+// secrets are left out, as the console usually ends up in shared logs.
+type StdoutNotifier struct{}
+
+func (StdoutNotifier) Notify(msg Notification) error {
+	body := strings.TrimSpace(msg.Body)
+	if msg.Secret {
+		body = "(withheld because it contains a secret; use NOTIFIER=file to read it)"
+	}
+	slog.Info("Notification", "to", msg.To, "subject", msg.Subject, "body", body)
+	return nil
+}
+
+// SMTPNotifier sends notifications as plain-text email. STARTTLS is used
+// whenever the server offers it.
+type SMTPNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPNotifier(host, port, username, password, from string) *SMTPNotifier {
+	n := &SMTPNotifier{addr: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n
+}
+
+func (n *SMTPNotifier) Notify(msg Notification) error {
+	to := stripNewlines(msg.To)
+	if !strings.Contains(to, "@") {
+		return fmt.Errorf("no email address for %q", to)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", stripNewlines(n.from))
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", stripNewlines(msg.Subject)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.TrimSpace(msg.Body), "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{to}, buf.Bytes())
+}
+
+// Header values must stay on one line
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+var notifier Notifier = StdoutNotifier{}
+
+// Pick the notifier from NOTIFIER (stdout, file or smtp). The file path comes
+// from NOTIFY_FILE; the mail server from SMTP_HOST, SMTP_PORT, SMTP_USERNAME,
+// SMTP_PASSWORD and SMTP_FROM.
+func loadNotifier() Notifier {
+	switch os.Getenv("NOTIFIER") {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			host = "localhost"
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "25"
+		}
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			from = "todo@localhost"
+		}
+		return NewSMTPNotifier(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	case "file":
+		path := os.Getenv("NOTIFY_FILE")
+		if path == "" {
+			path = "notifications.log"
+		}
+		return NewFileNotifier(path)
+	default:
+		return StdoutNotifier{}
+	}
+}
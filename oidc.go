@@ -0,0 +1,647 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+
+	"to-do-list/api"
+)
+
+// OIDCConfig holds the settings for single sign-on via an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	AdminGroups  []string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// How often an ID token with an unknown kid may make us fetch the key set again
+const jwksRefreshInterval = time.Minute
+
+// OIDCProvider talks to a single OpenID Connect issuer. Discovery metadata and
+// signing keys are fetched lazily so the server can start before the provider is up.
+// mu only guards the cached values; it is never held during a request to the provider.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+var oidcProvider *OIDCProvider
+
+// Load OIDC settings from the environment; SSO stays disabled unless an issuer is set
+func loadOIDCConfig() (OIDCConfig, bool) {
+	issuer := strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return OIDCConfig{}, false
+	}
+
+	config := OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       []string{"openid", "profile", "email"},
+		GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		AdminGroups:  []string{"admin"},
+	}
+	if config.RedirectURL == "" {
+		config.RedirectURL = appBaseURL() + api.Prefix + "/auth/oidc/callback"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		config.Scopes = strings.Fields(scopes)
+	}
+	if groups := os.Getenv("OIDC_ADMIN_GROUPS"); groups != "" {
+		config.AdminGroups = strings.Split(groups, ",")
+	}
+	return config, true
+}
+
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	resp, err := p.client.Get(p.config.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery: unexpected status %d", resp.StatusCode)
+	}
+
+	discovery = &oidcDiscovery{}
+	if err := json.NewDecoder(resp.Body).Decode(discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", discovery.Issuer)
+	}
+
+	p.mu.Lock()
+	p.discovery = discovery
+	p.mu.Unlock()
+	return discovery, nil
+}
+
+// Look up the signing key for kid. An unknown kid refreshes the key set, as the
+// provider may have rotated its keys, but at most once per jwksRefreshInterval
+// so that tokens with made-up kids cannot make us flood the provider.
+func (p *OIDCProvider) getKey(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	refresh := !ok && time.Since(p.keysFetched) >= jwksRefreshInterval
+	if refresh {
+		p.keysFetched = time.Now()
+	}
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !refresh {
+		return nil, fmt.Errorf("oidc jwks: no key with kid %q", kid)
+	}
+
+	keys, err := p.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc jwks: no key with kid %q", kid)
+	}
+	return key, nil
+}
+
+// Download the provider's RSA signing keys, by kid
+func (p *OIDCProvider) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Get(discovery.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// Build the authorization request URL for the code flow with PKCE (S256)
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange an authorization code for tokens and return the verified ID token claims
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (map[string]interface{}, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	ctx, span := startSpan(ctx, "oidc token exchange", spanClient)
+	defer span.finish()
+	span.setAttr("server.address", discovery.TokenEndpoint)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	injectTraceContext(ctx, req.Header)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		span.setError(err.Error())
+		return nil, fmt.Errorf("oidc token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	span.setAttr("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		span.setError(resp.Status)
+		return nil, fmt.Errorf("oidc token: unexpected status %d", resp.StatusCode)
+	}
+
+	var tokens oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("oidc token: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc token: response has no id_token")
+	}
+
+	return p.verifyIDToken(tokens.IDToken, nonce)
+}
+
+// Verify the ID token signature (RS256) and its standard claims
+func (p *OIDCProvider) verifyIDToken(rawToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token: malformed token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("id_token: malformed header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("id_token: malformed header")
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("id_token: unsupported algorithm %q", header.Alg)
+	}
+
+	key, err := p.getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("id_token: malformed signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("id_token: invalid signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("id_token: malformed payload")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("id_token: malformed payload")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != p.config.Issuer {
+		return nil, errors.New("id_token: issuer mismatch")
+	}
+	if !audienceContains(claims["aud"], p.config.ClientID) {
+		return nil, errors.New("id_token: audience mismatch")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Unix() > int64(exp)+60 {
+		return nil, errors.New("id_token: token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("id_token: nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id_token: missing subject")
+	}
+
+	return claims, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Map the configured groups claim to the admin/user roles. mapped is true only
+// when one of the groups is in OIDC_ADMIN_GROUPS; "user" is just the default.
+func (p *OIDCProvider) roleFromClaims(claims map[string]interface{}) (role string, mapped bool) {
+	var groups []string
+	switch v := claims[p.config.GroupsClaim].(type) {
+	case string:
+		groups = strings.Fields(v)
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	for _, group := range groups {
+		for _, adminGroup := range p.config.AdminGroups {
+			if group == strings.TrimSpace(adminGroup) {
+				return "admin", true
+			}
+		}
+	}
+	return "user", false
+}
+
+// Turn a provider username into one that passes our username rules
+func sanitizeUsername(name string) string {
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at]
+	}
+	var kept []rune
+	for _, char := range norm.NFC.String(name) {
+		if isUsernameChar(char) {
+			kept = append(kept, char)
+		}
+		if len(kept) == maxUsernameLen {
+			break
+		}
+	}
+	return string(kept)
+}
+
+// A profile claim that passes the rule, or "" so a bad value from the provider is left out
+func profileClaim(claims map[string]interface{}, name string, rule stringRule) string {
+	value, _ := claims[name].(string)
+	if rule.validate(&value) != "" {
+		return ""
+	}
+	return value
+}
+
+// Whether the provider vouches for the email claim. Some providers send the
+// flag as a string.
+func emailVerified(claims map[string]interface{}) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// Find the local account for an SSO identity, linking by subject, then by
+// email if the provider has verified it, and provisioning a new account just
+// in time if none matches. Usernames are never used to link: anyone can pick
+// a preferred_username at most providers.
+//
+// New accounts get role. Existing accounts keep the role an admin gave them
+// unless mapped says a group mapping matched, and even then the last user who
+// can manage users keeps that permission. Must be called with dataMu held.
+func provisionOIDCUser(claims map[string]interface{}, role string, mapped bool) *User {
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	username, _ := claims["preferred_username"].(string)
+	if username == "" {
+		username = email
+	}
+	username = sanitizeUsername(username)
+
+	var user *User
+	for i, u := range users {
+		if u.OIDCSubject == subject {
+			user = &users[i]
+			break
+		}
+	}
+	if user == nil && email != "" && emailVerified(claims) {
+		for i, u := range users {
+			if strings.EqualFold(u.Email, email) && u.OIDCSubject == "" && u.DeletedAt == "" {
+				user = &users[i]
+				break
+			}
+		}
+	}
+
+	if user != nil && user.DeletedAt != "" {
+		return user
+	}
+	if user != nil {
+		user.OIDCSubject = subject
+		if user.Email == "" && emailOwner(email, user.ID) == nil {
+			user.Email = email
+		}
+		if user.DisplayName == "" {
+			user.DisplayName = profileClaim(claims, "name", displayNameRule)
+		}
+		if user.AvatarURL == "" {
+			user.AvatarURL = profileClaim(claims, "picture", avatarURLRule)
+		}
+		if mapped && user.Role != role {
+			if roleHasPermission(user.Role, PermUsersManage) && !roleHasPermission(role, PermUsersManage) && countUserManagers(user.ID) == 0 {
+				slog.Warn("Keeping the role of the last user manager", "user", user.Username, "role", user.Role, "mapped_role", role)
+			} else {
+				user.Role = role
+			}
+		}
+		return user
+	}
+
+	// Avoid clashing with an account that is already linked to another identity
+	if username == "" || usernameOwner(username, 0) != nil {
+		username = sanitizeUsername(fmt.Sprintf("sso%d", nextUserID))
+	}
+	// Emails are unique, so one already in use stays with its current owner
+	if emailOwner(email, 0) != nil {
+		email = ""
+	}
+
+	users = append(users, User{
+		ID:          nextUserID,
+		Username:    username,
+		DisplayName: profileClaim(claims, "name", displayNameRule),
+		Role:        role,
+		Email:       email,
+		AvatarURL:   profileClaim(claims, "picture", avatarURLRule),
+		OIDCSubject: subject,
+	})
+	nextUserID++
+	publishUser(EventUserCreated, users[len(users)-1])
+	return &users[len(users)-1]
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// GET /auth/oidc/login — Redirect to the identity provider
+func oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	session, err := store.Get(r, "todo-session")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Session error")
+		return
+	}
+
+	state := randomToken(24)
+	nonce := randomToken(24)
+	verifier := randomToken(48)
+
+	authURL, err := oidcProvider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "OIDC login failed", "error", err)
+		writeError(w, r, http.StatusBadGateway, api.CodeUpstreamError, "Identity provider unavailable")
+		return
+	}
+
+	session.Values["oidc_state"] = state
+	session.Values["oidc_nonce"] = nonce
+	session.Values["oidc_verifier"] = verifier
+	if err := session.Save(r, w); err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Session error")
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// GET /auth/oidc/callback — Complete the code flow and start a session
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Single sign-on is not configured")
+		return
+	}
+
+	session, err := store.Get(r, "todo-session")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Session error")
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		recordLogin("oidc", false)
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Sign-in failed: "+providerErr)
+		return
+	}
+
+	state, _ := session.Values["oidc_state"].(string)
+	nonce, _ := session.Values["oidc_nonce"].(string)
+	verifier, _ := session.Values["oidc_verifier"].(string)
+	delete(session.Values, "oidc_state")
+	delete(session.Values, "oidc_nonce")
+	delete(session.Values, "oidc_verifier")
+
+	if state == "" || query.Get("state") != state {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid sign-in state")
+		return
+	}
+
+	claims, err := oidcProvider.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "OIDC callback failed", "error", err)
+		recordLogin("oidc", false)
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Sign-in failed")
+		return
+	}
+
+	// The token exchange above is network I/O, so the data is only locked from here
+	dataMu.Lock()
+	role, mapped := oidcProvider.roleFromClaims(claims)
+	user := *provisionOIDCUser(claims, role, mapped)
+	dataMu.Unlock()
+	if user.DeletedAt != "" {
+		recordLogin("oidc", false)
+		writeError(w, r, http.StatusForbidden, api.CodeForbidden, "This account has been deleted")
+		return
+	}
+
+	twoFactorRequired := beginLogin(session, &user)
+	if err := session.Save(r, w); err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Session error")
+		return
+	}
+
+	if twoFactorRequired {
+		http.Redirect(w, r, "/?two_factor=required", http.StatusFound)
+		return
+	}
+	recordLogin("oidc", true)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// GET /auth/oidc/config — Tell the frontend whether SSO is available
+func oidcStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":   oidcProvider != nil,
+		"login_url": api.Prefix + "/auth/oidc/login",
+	})
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"to-do-list/api"
+)
+
+// An authorization request the mock provider has answered with a code
+type mockAuthRequest struct {
+	challenge string
+	nonce     string
+}
+
+// A minimal OpenID Connect provider: discovery, an authorization endpoint that
+// approves everyone, a token endpoint that checks PKCE and a JWKS endpoint.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu          sync.Mutex
+	codes       map[string]mockAuthRequest
+	claims      map[string]interface{} // Extra claims for the next ID tokens
+	nonce       string                 // Overrides the nonce from the authorization request
+	signer      *rsa.PrivateKey        // Overrides the key ID tokens are signed with
+	jwksFetches int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, kid: "key-1", codes: make(map[string]mockAuthRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.jwksFetches++
+		p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kid: p.kid,
+			Kty: "RSA",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "authorization code flow with S256 PKCE required", http.StatusBadRequest)
+		return
+	}
+	code := randomToken(16)
+	p.mu.Lock()
+	p.codes[code] = mockAuthRequest{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	p.mu.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	auth, ok := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code"))
+	verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || r.Form.Get("grant_type") != "authorization_code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   p.server.URL,
+		"aud":   "todo-app",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": auth.nonce,
+	}
+	if p.nonce != "" {
+		claims["nonce"] = p.nonce
+	}
+	for name, value := range p.claims {
+		claims[name] = value
+	}
+	signer := p.key
+	if p.signer != nil {
+		signer = p.signer
+	}
+	json.NewEncoder(w).Encode(oidcTokenResponse{TokenType: "Bearer", AccessToken: "access", IDToken: signIDToken(signer, p.kid, claims)})
+}
+
+func signIDToken(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Point the app at the mock provider and serve it, with the auth rate limit off
+func startOIDCApp(t *testing.T, provider *mockProvider) *httptest.Server {
+	t.Helper()
+	useTestData(t)
+	authLimit := defaultRateLimits[RateLimitAuth]
+	t.Cleanup(func() { oidcProvider, defaultRateLimits[RateLimitAuth] = nil, authLimit })
+	defaultRateLimits[RateLimitAuth] = RateLimit{}
+
+	app := httptest.NewServer(newRouter())
+	t.Cleanup(app.Close)
+	oidcProvider = NewOIDCProvider(OIDCConfig{
+		Issuer:      provider.server.URL,
+		ClientID:    "todo-app",
+		RedirectURL: app.URL + api.Prefix + "/auth/oidc/callback",
+		Scopes:      []string{"openid", "profile", "email"},
+		GroupsClaim: "groups",
+		AdminGroups: []string{"admin"},
+	})
+	return app
+}
+
+// A browser that does not follow redirects, so each step can be checked
+func newBrowser(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+func get(t *testing.T, client *http.Client, target string) *http.Response {
+	t.Helper()
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// Run the login redirect and the provider's approval, returning the callback URL
+func startSSO(t *testing.T, client *http.Client, app *httptest.Server) string {
+	t.Helper()
+	resp := get(t, client, app.URL+api.Prefix+"/auth/oidc/login")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login: status %d, want 302", resp.StatusCode)
+	}
+	resp = get(t, client, resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d, want 302", resp.StatusCode)
+	}
+	return resp.Header.Get("Location")
+}
+
+// Sign in through the provider and return the user the app logged in
+func signInWithSSO(t *testing.T, app *httptest.Server) api.CurrentUser {
+	t.Helper()
+	client := newBrowser(t)
+	resp := get(t, client, startSSO(t, client, app))
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/" {
+		t.Fatalf("callback: status %d to %q, want 302 to /", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	me, err := client.Get(app.URL + api.Prefix + "/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer me.Body.Close()
+	var user api.CurrentUser
+	if err := json.NewDecoder(me.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	provider := newMockProvider(t)
+	app := startOIDCApp(t, provider)
+	provider.claims = map[string]interface{}{"sub": "sub-dana", "preferred_username": "dana", "email": "dana@example.com", "email_verified": true}
+
+	user := signInWithSSO(t, app)
+	if user.Username != "dana" || user.Role != "user" {
+		t.Fatalf("logged in as %q with role %q, want new user dana with role user", user.Username, user.Role)
+	}
+
+	// The second sign-in finds the account by subject
+	if again := signInWithSSO(t, app); again.ID != user.ID {
+		t.Fatalf("second sign-in: user %d, want %d", again.ID, user.ID)
+	}
+	dataMu.RLock()
+	defer dataMu.RUnlock()
+	if len(users) != 5 {
+		t.Fatalf("%d users, want 5", len(users))
+	}
+}
+
+// An SSO session must not be enough to give the account a local password,
+// which would let it sign in without the identity provider
+func TestSSOOnlyAccountCannotSetAPassword(t *testing.T) {
+	provider := newMockProvider(t)
+	app := startOIDCApp(t, provider)
+	provider.claims = map[string]interface{}{"sub": "sub-dana", "preferred_username": "dana"}
+
+	client := newBrowser(t)
+	if resp := get(t, client, startSSO(t, client, app)); resp.StatusCode != http.StatusFound {
+		t.Fatalf("callback: status %d, want 302", resp.StatusCode)
+	}
+	resp, err := client.Post(app.URL+api.Prefix+"/update-password", "application/json",
+		strings.NewReader(`{"currentPassword":"","newPassword":"Correct-horse-9"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("setting a password: status %d, want 400", resp.StatusCode)
+	}
+	dataMu.RLock()
+	defer dataMu.RUnlock()
+	if dana := findUserByUsername(context.Background(), "dana"); dana == nil || dana.Password != "" {
+		t.Fatalf("dana %+v, want an SSO account without a password", dana)
+	}
+}
+
+func TestOIDCLinksOnlyBySubjectOrVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		wantUser string
+	}{
+		{"verified email", map[string]interface{}{"sub": "sub-1", "email": "ALICE@example.com", "email_verified": true}, "alice"},
+		{"verified email as string", map[string]interface{}{"sub": "sub-2", "email": "alice@example.com", "email_verified": "true"}, "alice"},
+		{"unverified email", map[string]interface{}{"sub": "sub-3", "preferred_username": "mallory", "email": "alice@example.com"}, "mallory"},
+		{"same username", map[string]interface{}{"sub": "sub-4", "preferred_username": "alice"}, "sso5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newMockProvider(t)
+			app := startOIDCApp(t, provider)
+			dataMu.Lock()
+			users[1].Email = "alice@example.com"
+			dataMu.Unlock()
+			provider.claims = tt.claims
+
+			if user := signInWithSSO(t, app); user.Username != tt.wantUser {
+				t.Fatalf("logged in as %q, want %q", user.Username, tt.wantUser)
+			}
+			dataMu.RLock()
+			defer dataMu.RUnlock()
+			if users[1].OIDCSubject != "" && tt.wantUser != "alice" {
+				t.Fatalf("alice was linked to %q", users[1].OIDCSubject)
+			}
+		})
+	}
+}
+
+func TestOIDCRoleMapping(t *testing.T) {
+	provider := newMockProvider(t)
+	app := startOIDCApp(t, provider)
+	dataMu.Lock()
+	users[2].Email, users[2].Role = "bob@example.com", "admin"
+	users[3].Email = "charlie@example.com"
+	dataMu.Unlock()
+
+	// A role given by an admin survives a login without a mapped group
+	provider.claims = map[string]interface{}{"sub": "sub-bob", "email": "bob@example.com", "email_verified": true}
+	if user := signInWithSSO(t, app); user.Role != "admin" {
+		t.Fatalf("bob has role %q after a login without groups, want admin", user.Role)
+	}
+	provider.claims = map[string]interface{}{"sub": "sub-charlie", "email": "charlie@example.com", "email_verified": true, "groups": []string{"admin"}}
+	if user := signInWithSSO(t, app); user.Role != "admin" {
+		t.Fatalf("charlie has role %q after a login in the admin group, want admin", user.Role)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		setup  func(p *mockProvider)
+		tamper func(callback string) string
+		want   int
+	}{
+		{"wrong state", nil, func(callback string) string {
+			return strings.Replace(callback, "state=", "state=x", 1)
+		}, http.StatusBadRequest},
+		{"wrong nonce", func(p *mockProvider) { p.nonce = "replayed" }, nil, http.StatusUnauthorized},
+		{"bad signature", func(p *mockProvider) { p.signer = other }, nil, http.StatusUnauthorized},
+		{"unknown code", nil, func(callback string) string {
+			return strings.Replace(callback, "code=", "code=x", 1)
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newMockProvider(t)
+			app := startOIDCApp(t, provider)
+			provider.claims = map[string]interface{}{"sub": "sub-eve", "preferred_username": "eve"}
+			if tt.setup != nil {
+				tt.setup(provider)
+			}
+
+			client := newBrowser(t)
+			callback := startSSO(t, client, app)
+			if tt.tamper != nil {
+				callback = tt.tamper(callback)
+			}
+
+			resp := get(t, client, callback)
+			if resp.StatusCode != tt.want {
+				t.Fatalf("callback: status %d, want %d", resp.StatusCode, tt.want)
+			}
+			dataMu.RLock()
+			defer dataMu.RUnlock()
+			if len(users) != 4 {
+				t.Fatalf("%d users after a rejected login, want 4", len(users))
+			}
+		})
+	}
+}
+
+func TestOIDCUnknownKeyRefetchIsLimited(t *testing.T) {
+	provider := newMockProvider(t)
+	startOIDCApp(t, provider)
+
+	for i := 0; i < 3; i++ {
+		if _, err := oidcProvider.getKey("unknown"); err == nil {
+			t.Fatal("unknown kid was accepted")
+		}
+	}
+	if _, err := oidcProvider.getKey(provider.kid); err != nil {
+		t.Fatalf("known kid: %v", err)
+	}
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.jwksFetches != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", provider.jwksFetches)
+	}
+}
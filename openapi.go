@@ -0,0 +1,40 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+	"time"
+
+	"to-do-list/api"
+)
+
+// The hand-maintained OpenAPI 3 description of the API, checked against the
+// router by TestRoutesMatchOpenAPI
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// GET /api/v1/openapi.json — Serve the OpenAPI document
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	w.Write(openAPISpec)
+}
+
+// When the unversioned paths were deprecated in favour of /api/v1
+var aliasesDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// Mark responses from the old unversioned paths as deprecated and point clients
+// at the /api/v1 equivalent. RFC 9745 gives the deprecation date as "@" and
+// seconds since the epoch.
+func deprecatedAliasMiddleware(next http.Handler) http.Handler {
+	deprecation := "@" + strconv.FormatInt(aliasesDeprecatedAt.Unix(), 10)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", deprecation)
+		w.Header().Set("Link", "<"+api.Prefix+r.URL.Path+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
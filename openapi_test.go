@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"to-do-list/api"
+)
+
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths map[string]map[string]struct {
+		OperationID string `json:"operationId"`
+		Parameters  []struct {
+			Name string `json:"name"`
+			In   string `json:"in"`
+		} `json:"parameters"`
+		Responses map[string]json.RawMessage `json:"responses"`
+	} `json:"paths"`
+}
+
+func loadOpenAPI(t *testing.T) openAPIDocument {
+	t.Helper()
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("openapi = %q, want an OpenAPI 3 document", doc.OpenAPI)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != api.Prefix {
+		t.Fatalf("servers = %+v, want a single %s server", doc.Servers, api.Prefix)
+	}
+	return doc
+}
+
+// Every route as "METHOD /path", leaving out CORS preflight routes
+func routeOperations(t *testing.T, r *mux.Router) map[string]bool {
+	t.Helper()
+	ops := make(map[string]bool)
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if method != http.MethodOptions {
+				ops[method+" "+path] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+func missingFrom(want, have map[string]bool) []string {
+	var missing []string
+	for op := range want {
+		if !have[op] {
+			missing = append(missing, op)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	documented := make(map[string]bool)
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+api.Prefix+path] = true
+		}
+	}
+
+	routed := make(map[string]bool)
+	for op := range routeOperations(t, newRouter()) {
+		if strings.Contains(op, " "+api.Prefix+"/") {
+			routed[op] = true
+		}
+	}
+
+	for _, op := range missingFrom(routed, documented) {
+		t.Errorf("route %s is not in openapi.json", op)
+	}
+	for _, op := range missingFrom(documented, routed) {
+		t.Errorf("openapi.json documents %s, but no route serves it", op)
+	}
+}
+
+func TestOpenAPIOperationsAreComplete(t *testing.T) {
+	doc := loadOpenAPI(t)
+	pathParam := regexp.MustCompile(`\{(\w+)\}`)
+	operationIDs := make(map[string]string)
+
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			name := strings.ToUpper(method) + " " + path
+			if op.OperationID == "" {
+				t.Errorf("%s has no operationId", name)
+			} else if other, ok := operationIDs[op.OperationID]; ok {
+				t.Errorf("%s and %s share operationId %q", name, other, op.OperationID)
+			}
+			operationIDs[op.OperationID] = name
+
+			if len(op.Responses) == 0 {
+				t.Errorf("%s documents no responses", name)
+			}
+			for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+				found := false
+				for _, param := range op.Parameters {
+					found = found || (param.In == "path" && param.Name == match[1])
+				}
+				if !found {
+					t.Errorf("%s does not describe path parameter %q", name, match[1])
+				}
+			}
+		}
+	}
+}
+
+// Served outside the API, so they have no versioned equivalent
+var unversionedPaths = map[string]bool{"/": true, "/metrics": true, "/healthz": true, "/readyz": true, "/version": true}
+
+func TestLegacyPathsAliasVersionedRoutes(t *testing.T) {
+	versioned := make(map[string]bool)
+	legacy := make(map[string]bool)
+	for op := range routeOperations(t, newRouter()) {
+		method, path, _ := strings.Cut(op, " ")
+		if rest, ok := strings.CutPrefix(path, api.Prefix); ok {
+			if rest != "/openapi.json" {
+				versioned[method+" "+rest] = true
+			}
+		} else if !unversionedPaths[path] {
+			legacy[op] = true
+		}
+	}
+
+	for _, op := range missingFrom(versioned, legacy) {
+		t.Errorf("%s has no deprecated alias without %s", op, api.Prefix)
+	}
+	for _, op := range missingFrom(legacy, versioned) {
+		t.Errorf("%s has no %s equivalent", op, api.Prefix)
+	}
+}
+
+func TestDeprecatedAliasHeaders(t *testing.T) {
+	router := newRouter()
+
+	tests := []struct {
+		path       string
+		status     int
+		deprecated bool
+	}{
+		{api.Prefix + "/openapi.json", http.StatusOK, false},
+		{api.Prefix + "/todos", http.StatusUnauthorized, false},
+		{"/todos", http.StatusUnauthorized, true},
+		{api.Prefix + "/no-such-endpoint", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if rec.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("Deprecation") != ""; got != tt.deprecated {
+			t.Errorf("GET %s: deprecated = %v, want %v", tt.path, got, tt.deprecated)
+		}
+		if tt.deprecated {
+			if got := rec.Header().Get("Deprecation"); got != "@1792368000" {
+				t.Errorf("GET %s: Deprecation = %q, want the date as @<seconds since the epoch>", tt.path, got)
+			}
+			if link := rec.Header().Get("Link"); link != `<`+api.Prefix+tt.path+`>; rel="successor-version"` {
+				t.Errorf("GET %s: Link = %q", tt.path, link)
+			}
+		}
+		if tt.status >= 400 && rec.Header().Get("Content-Type") != api.ProblemContentType {
+			t.Errorf("GET %s: Content-Type = %q, want %s", tt.path, rec.Header().Get("Content-Type"), api.ProblemContentType)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"to-do-list/api"
+)
+
+//go:embed common-passwords.txt
+var bundledPasswordDenylist string
+
+// PasswordPolicy describes the rules every new password must satisfy
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	Denylist      map[string]bool
+}
+
+var passwordPolicy = defaultPasswordPolicy()
+
+func defaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:    8,
+		MaxLength:    128,
+		RequireLower: true,
+		RequireDigit: true,
+		Denylist:     parseDenylist(bundledPasswordDenylist),
+	}
+}
+
+// Load the password policy from the environment, starting from the defaults
+func loadPasswordPolicy() PasswordPolicy {
+	policy := defaultPasswordPolicy()
+
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && v > 0 {
+		policy.MinLength = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH")); err == nil && v >= policy.MinLength {
+		policy.MaxLength = v
+	}
+	policy.RequireUpper = envBool("PASSWORD_REQUIRE_UPPER", policy.RequireUpper)
+	policy.RequireLower = envBool("PASSWORD_REQUIRE_LOWER", policy.RequireLower)
+	policy.RequireDigit = envBool("PASSWORD_REQUIRE_DIGIT", policy.RequireDigit)
+	policy.RequireSymbol = envBool("PASSWORD_REQUIRE_SYMBOL", policy.RequireSymbol)
+
+	// An extra denylist file adds to the bundled one
+	if path := os.Getenv("PASSWORD_DENYLIST_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("Could not read password denylist", "path", path, "error", err)
+		} else {
+			for word := range parseDenylist(string(data)) {
+				policy.Denylist[word] = true
+			}
+		}
+	}
+
+	return policy
+}
+
+func envBool(key string, fallback bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func parseDenylist(data string) map[string]bool {
+	denylist := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denylist[strings.ToLower(line)] = true
+	}
+	return denylist
+}
+
+// Validate a new password for the given username; returns "" when it is acceptable
+func (p PasswordPolicy) Validate(password, username string) string {
+	length := len([]rune(password))
+	if length < p.MinLength {
+		return fmt.Sprintf("Password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Sprintf("Password must be %d characters or less", p.MaxLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		case unicode.IsPunct(char) || unicode.IsSymbol(char):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		return "Password must contain an uppercase letter"
+	}
+	if p.RequireLower && !hasLower {
+		return "Password must contain a lowercase letter"
+	}
+	if p.RequireDigit && !hasDigit {
+		return "Password must contain a number"
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return "Password must contain a symbol"
+	}
+
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return "Password must not contain the username"
+	}
+	if p.Denylist[strings.ToLower(password)] {
+		return "Password is too common, please choose another"
+	}
+	return ""
+}
+
+const passwordResetTTL = time.Hour
+
+type passwordReset struct {
+	UserID    int
+	ExpiresAt time.Time
+}
+
+// Outstanding reset tokens, keyed by the SHA-256 hash of the token
+var (
+	passwordResets   = make(map[string]passwordReset)
+	passwordResetsMu sync.Mutex
+)
+
+type PasswordResetRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Base URL used in links sent to users
+func appBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return serverBaseURL
+}
+
+// POST /password-reset/request — Send a reset link to the account owner
+func requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	// Same response whether or not the account exists, so this can't be used to probe usernames
+	response := map[string]string{"message": "If the account exists, a reset link has been sent"}
+
+	var user *User
+	dataMu.RLock()
+	for _, u := range users {
+		if u.DeletedAt != "" {
+			continue
+		}
+		if (req.Username != "" && sameUsername(u.Username, req.Username)) ||
+			(req.Email != "" && u.Email != "" && strings.EqualFold(u.Email, req.Email)) {
+			user = &u
+			break
+		}
+	}
+	dataMu.RUnlock()
+	if user == nil {
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Accounts that only sign in through SSO have no password here, and a reset
+	// must not give them one: their provider decides who can sign in. They are
+	// told where to go instead.
+	if user.Password == "" && user.OIDCSubject != "" {
+		sendNotification(Notification{
+			To:      notificationAddress(user),
+			Subject: "Signing in to Team To-Do",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password, but your account signs in with single sign-on and has no password. Use \"Sign in with SSO\" at %s instead.\n\nIf you didn't ask for this, you can ignore this message.",
+				user.Username, appBaseURL()+"/"),
+		})
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	token := randomToken(32)
+	passwordResetsMu.Lock()
+	now := time.Now()
+	for hash, reset := range passwordResets {
+		if reset.UserID == user.ID || now.After(reset.ExpiresAt) {
+			delete(passwordResets, hash)
+		}
+	}
+	passwordResets[hashResetToken(token)] = passwordReset{UserID: user.ID, ExpiresAt: now.Add(passwordResetTTL)}
+	passwordResetsMu.Unlock()
+
+	// Sent in the background: a slow mail server would otherwise make the
+	// response time tell whether the account exists
+	link := appBaseURL() + "/?reset_token=" + url.QueryEscape(token)
+	sendNotification(Notification{
+		To:      notificationAddress(user),
+		Subject: "Reset your Team To-Do password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes.\n\n%s\n\nIf you didn't ask for this, you can ignore this message.",
+			user.Username, int(passwordResetTTL.Minutes()), link),
+		Secret: true,
+	})
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// POST /password-reset/confirm — Set a new password using a reset token
+func confirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	// Hold the lock until the token is consumed so it can only be used once
+	hash := hashResetToken(req.Token)
+	passwordResetsMu.Lock()
+	defer passwordResetsMu.Unlock()
+
+	reset, ok := passwordResets[hash]
+	if !ok || time.Now().After(reset.ExpiresAt) {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Reset link is invalid or has expired")
+		return
+	}
+
+	user := findUserByID(r.Context(), reset.UserID)
+	if user == nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Reset link is invalid or has expired")
+		return
+	}
+
+	if msg := passwordPolicy.Validate(req.NewPassword, user.Username); msg != "" {
+		writeFieldError(w, r, "new_password", msg)
+		return
+	}
+
+	delete(passwordResets, hash)
+	user.Password = req.NewPassword
+	// Whoever knew the old password may still be logged in
+	revokeSessions(user)
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"to-do-list/api"
+)
+
+// Rate limit classes. Each caller has a separate budget for each class.
+const (
+	RateLimitAuth  = "auth"  // Logins, second factors, password resets and changes
+	RateLimitRead  = "read"  // GET requests
+	RateLimitWrite = "write" // Everything else
+)
+
+var rateLimitClasses = []string{RateLimitAuth, RateLimitRead, RateLimitWrite}
+
+// A token bucket: Burst requests at once, refilled at PerMinute requests a minute.
+// PerMinute 0 turns limiting off.
+type RateLimit struct {
+	PerMinute int `json:"per_minute"`
+	Burst     int `json:"burst,omitempty"` // Defaults to PerMinute
+}
+
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.PerMinute)
+}
+
+// Limits for anonymous callers and for roles that don't set their own,
+// overridden by RATE_LIMIT_AUTH, RATE_LIMIT_READ and RATE_LIMIT_WRITE
+var defaultRateLimits = map[string]RateLimit{
+	RateLimitAuth:  {PerMinute: 10, Burst: 5},
+	RateLimitRead:  {PerMinute: 600, Burst: 120},
+	RateLimitWrite: {PerMinute: 120, Burst: 30},
+}
+
+// Routes, relative to the API prefix, that count against the auth budget
+var authRateLimitRoutes = map[string]bool{
+	"/login":                  true,
+	"/login/2fa":              true,
+	"/password-reset/request": true,
+	"/password-reset/confirm": true,
+	"/update-password":        true,
+	"/auth/oidc/login":        true,
+	"/auth/oidc/callback":     true,
+}
+
+// How often idle buckets are swept out of rateBuckets
+const rateBucketSweepInterval = time.Minute
+
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// Buckets keyed by class and caller, with their own lock so they never depend on the data lock
+var (
+	rateLimitMu     sync.Mutex
+	rateBuckets     = make(map[string]*rateBucket)
+	rateBucketSwept = time.Now()
+)
+
+// Load the default limits from RATE_LIMIT_<CLASS>, given as "per_minute" or
+// "per_minute,burst", e.g. RATE_LIMIT_WRITE=120,30
+func loadRateLimits() {
+	for _, class := range rateLimitClasses {
+		name := "RATE_LIMIT_" + strings.ToUpper(class)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		limit, err := parseRateLimit(value)
+		if err != nil {
+			slog.Warn("Invalid "+name, "value", value, "using", fmt.Sprintf("%d,%d", defaultRateLimits[class].PerMinute, defaultRateLimits[class].Burst))
+			continue
+		}
+		defaultRateLimits[class] = limit
+	}
+}
+
+func parseRateLimit(value string) (RateLimit, error) {
+	perMinute, burst, hasBurst := strings.Cut(value, ",")
+	var limit RateLimit
+	var err error
+	if limit.PerMinute, err = strconv.Atoi(strings.TrimSpace(perMinute)); err != nil {
+		return RateLimit{}, err
+	}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil {
+			return RateLimit{}, err
+		}
+	}
+	if msg := validateRateLimit(limit); msg != "" {
+		return RateLimit{}, errors.New(msg)
+	}
+	return limit, nil
+}
+
+func validateRateLimit(limit RateLimit) string {
+	if limit.PerMinute < 0 || limit.Burst < 0 {
+		return "Rate limits cannot be negative"
+	}
+	return ""
+}
+
+// The limit for a class, from the role when it sets one
+func rateLimitFor(roleName, class string) RateLimit {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	if role, ok := roles[roleName]; ok {
+		if limit, ok := role.RateLimits[class]; ok {
+			return limit
+		}
+	}
+	return defaultRateLimits[class]
+}
+
+// Take a token from the caller's bucket. It returns whether the request may
+// go ahead, the whole tokens left, and how long until the next token and until
+// the bucket is full again.
+func takeRateToken(key string, limit RateLimit, now time.Time) (allowed bool, remaining int, retryAfter, reset time.Duration) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+
+	if now.Sub(rateBucketSwept) >= rateBucketSweepInterval {
+		sweepRateBuckets(now)
+	}
+
+	capacity := limit.capacity()
+	perSecond := float64(limit.PerMinute) / 60
+	b := rateBuckets[key]
+	if b == nil || b.limit != limit {
+		// New callers, and callers whose limit was just changed, start with a full bucket
+		b = &rateBucket{tokens: capacity, updated: now, limit: limit}
+		rateBuckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		allowed = true
+	} else {
+		retryAfter = seconds((1 - b.tokens) / perSecond)
+	}
+	return allowed, int(b.tokens), retryAfter, seconds((capacity - b.tokens) / perSecond)
+}
+
+// Drop buckets that have refilled, which is the same as not having one
+func sweepRateBuckets(now time.Time) {
+	for key, b := range rateBuckets {
+		perSecond := float64(b.limit.PerMinute) / 60
+		if b.tokens+now.Sub(b.updated).Seconds()*perSecond >= b.limit.capacity() {
+			delete(rateBuckets, key)
+		}
+	}
+	rateBucketSwept = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// The class of a request, or "" for requests that are never limited
+func rateLimitClass(r *http.Request) string {
+	if r.Method == http.MethodOptions || probePaths[r.URL.Path] || r.URL.Path == "/metrics" {
+		return ""
+	}
+	if current := mux.CurrentRoute(r); current != nil {
+		route, _ := current.GetPathTemplate()
+		if authRateLimitRoutes[strings.TrimPrefix(route, api.Prefix)] {
+			return RateLimitAuth
+		}
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return RateLimitRead
+	}
+	return RateLimitWrite
+}
+
+// The logged-in user's ID and role, or the client IP for anonymous requests.
+// The role is looked up fresh, as authMiddleware does.
+func rateLimitKey(r *http.Request) (key, role string) {
+	if session, err := store.Get(r, "todo-session"); err == nil {
+		if id, ok := session.Values["user_id"].(int); ok {
+			dataMu.RLock()
+			user := findUserByID(r.Context(), id)
+			if user != nil {
+				key, role = "user:"+strconv.Itoa(user.ID), user.Role
+			}
+			dataMu.RUnlock()
+			if key != "" {
+				return key, role
+			}
+		}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return "ip:" + ip, ""
+}
+
+// Limit each caller to their budget for the request's class. Every limited
+// response carries RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset;
+// a request over the limit gets a 429 with Retry-After.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := rateLimitClass(r)
+		if class == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, role := rateLimitKey(r)
+		limit := rateLimitFor(role, class)
+		if limit.PerMinute == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, remaining, retryAfter, reset := takeRateToken(class+"/"+key, limit, time.Now())
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(int(limit.capacity())))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+		if !allowed {
+			recordRateLimited(class)
+			wait := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(wait))
+			writeError(w, r, http.StatusTooManyRequests, api.CodeRateLimited, fmt.Sprintf("Too many %s requests, try again in %d seconds", class, wait))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"to-do-list/api"
+)
+
+// Start each test with no buckets and the given default limits
+func useRateLimits(t *testing.T, limits map[string]RateLimit) {
+	t.Helper()
+	savedLimits, savedBuckets := defaultRateLimits, rateBuckets
+	t.Cleanup(func() {
+		rateLimitMu.Lock()
+		defaultRateLimits, rateBuckets = savedLimits, savedBuckets
+		rateLimitMu.Unlock()
+	})
+	rateLimitMu.Lock()
+	defaultRateLimits, rateBuckets = limits, make(map[string]*rateBucket)
+	rateLimitMu.Unlock()
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    RateLimit
+		wantErr bool
+	}{
+		{"120", RateLimit{PerMinute: 120}, false},
+		{"120, 30", RateLimit{PerMinute: 120, Burst: 30}, false},
+		{"0", RateLimit{}, false},
+		{"-1", RateLimit{}, true},
+		{"fast", RateLimit{}, true},
+		{"60,many", RateLimit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRateLimit(%q) = %+v, %v; want %+v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTakeRateToken(t *testing.T) {
+	useRateLimits(t, defaultRateLimits)
+	limit := RateLimit{PerMinute: 60, Burst: 3}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if allowed, remaining, _, _ := takeRateToken("k", limit, now); !allowed || remaining != 2-i {
+			t.Fatalf("request %d: allowed %v, remaining %d; want allowed with %d left", i+1, allowed, remaining, 2-i)
+		}
+	}
+	allowed, _, retryAfter, reset := takeRateToken("k", limit, now)
+	if allowed {
+		t.Fatal("request over the burst was allowed")
+	}
+	if retryAfter != time.Second || reset != 3*time.Second {
+		t.Fatalf("retry after %v, reset %v; want 1s and 3s", retryAfter, reset)
+	}
+
+	// One token a second comes back
+	if allowed, _, _, _ := takeRateToken("k", limit, now.Add(time.Second)); !allowed {
+		t.Fatal("request after the refill was rejected")
+	}
+	// Other callers have their own bucket
+	if allowed, _, _, _ := takeRateToken("other", limit, now); !allowed {
+		t.Fatal("another caller was limited")
+	}
+	// A changed limit starts a full bucket
+	if allowed, remaining, _, _ := takeRateToken("k", RateLimit{PerMinute: 60, Burst: 5}, now.Add(time.Second)); !allowed || remaining != 4 {
+		t.Fatalf("after a limit change: allowed %v, remaining %d; want allowed with 4 left", allowed, remaining)
+	}
+}
+
+func TestSweepRateBuckets(t *testing.T) {
+	useRateLimits(t, defaultRateLimits)
+	limit := RateLimit{PerMinute: 60, Burst: 2}
+	now := time.Now()
+	takeRateToken("idle", limit, now)
+	takeRateToken("busy", limit, now.Add(time.Minute))
+	takeRateToken("busy", limit, now.Add(time.Minute))
+
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	sweepRateBuckets(now.Add(time.Minute))
+	if _, ok := rateBuckets["idle"]; ok {
+		t.Error("a full bucket was kept")
+	}
+	if _, ok := rateBuckets["busy"]; !ok {
+		t.Error("a bucket in use was dropped")
+	}
+}
+
+func sendRequest(t *testing.T, handler http.Handler, method, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(`{"username":"alice","password":"wrong"}`))
+	req.RemoteAddr = "192.0.2.1:1234"
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{
+		RateLimitAuth:  {PerMinute: 60, Burst: 2},
+		RateLimitRead:  {PerMinute: 60, Burst: 1},
+		RateLimitWrite: {},
+	})
+	router := newRouter()
+
+	for i := 0; i < 2; i++ {
+		rec := sendRequest(t, router, http.MethodPost, api.Prefix+"/login")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("login %d: status %d, want 401", i+1, rec.Code)
+		}
+		if rec.Header().Get("RateLimit-Limit") != "2" || rec.Header().Get("RateLimit-Remaining") != strconv.Itoa(1-i) {
+			t.Fatalf("login %d: RateLimit-Limit %q, RateLimit-Remaining %q", i+1, rec.Header().Get("RateLimit-Limit"), rec.Header().Get("RateLimit-Remaining"))
+		}
+	}
+	rec := sendRequest(t, router, http.MethodPost, api.Prefix+"/login")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("third login: status %d, Retry-After %q; want 429 after 1 second", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(rec.Body.String(), api.CodeRateLimited) {
+		t.Fatalf("third login: body %s has no %s code", rec.Body, api.CodeRateLimited)
+	}
+
+	// The legacy alias shares the budget of the versioned route
+	if rec := sendRequest(t, router, http.MethodPost, "/login"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("legacy login: status %d, want 429", rec.Code)
+	}
+	// Probes and write requests with the limit turned off are never limited
+	for i := 0; i < 3; i++ {
+		if rec := sendRequest(t, router, http.MethodGet, "/healthz"); rec.Code != http.StatusOK {
+			t.Fatalf("healthz: status %d, want 200", rec.Code)
+		}
+		if rec := sendRequest(t, router, http.MethodPost, api.Prefix+"/logout"); rec.Code == http.StatusTooManyRequests {
+			t.Fatal("write request was limited with the write limit off")
+		}
+	}
+}
+
+// Logged-in callers are counted by user, with the limits of their role
+func TestRateLimitByUserAndRole(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{
+		RateLimitAuth:  {},
+		RateLimitRead:  {PerMinute: 60, Burst: 1},
+		RateLimitWrite: {},
+	})
+	rolesMu.Lock()
+	saved := roles["admin"].RateLimits
+	roles["admin"].RateLimits = map[string]RateLimit{RateLimitRead: {PerMinute: 600, Burst: 5}}
+	rolesMu.Unlock()
+	t.Cleanup(func() {
+		rolesMu.Lock()
+		roles["admin"].RateLimits = saved
+		rolesMu.Unlock()
+	})
+	router := newRouter()
+
+	login := func(username, password string) *http.Cookie {
+		req := httptest.NewRequest(http.MethodPost, api.Prefix+"/login", strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login as %s: status %d", username, rec.Code)
+		}
+		return rec.Result().Cookies()[0]
+	}
+	alice, admin := login("alice", "password123"), login("admin", "admin")
+
+	if rec := sendRequest(t, router, http.MethodGet, api.Prefix+"/todos", alice); rec.Code != http.StatusOK {
+		t.Fatalf("alice's first read: status %d, want 200", rec.Code)
+	}
+	if rec := sendRequest(t, router, http.MethodGet, api.Prefix+"/todos", alice); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("alice's second read: status %d, want 429", rec.Code)
+	}
+	// Anonymous callers from the same IP have their own bucket
+	if rec := sendRequest(t, router, http.MethodGet, api.Prefix+"/todos"); rec.Code == http.StatusTooManyRequests {
+		t.Fatal("anonymous read from alice's IP was limited")
+	}
+	// The admin role allows more
+	for i := 0; i < 5; i++ {
+		if rec := sendRequest(t, router, http.MethodGet, api.Prefix+"/todos", admin); rec.Code != http.StatusOK {
+			t.Fatalf("admin read %d: status %d, want 200", i+1, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"to-do-list/api"
+)
+
+// Permissions checked by the handlers. ":own" applies to todos assigned to the
+// caller, ":any" to every todo.
+const (
+	PermTodosReadOwn     = "todos:read:own"
+	PermTodosReadAny     = "todos:read:any"
+	PermTodosCreateOwn   = "todos:create:own"
+	PermTodosCreateAny   = "todos:create:any"
+	PermTodosCompleteOwn = "todos:complete:own"
+	PermTodosCompleteAny = "todos:complete:any"
+	PermTodosDeleteOwn   = "todos:delete:own"
+	PermTodosDeleteAny   = "todos:delete:any"
+	PermUsersRead        = "users:read"
+	PermUsersManage      = "users:manage"
+	PermRolesManage      = "roles:manage"
+	PermWebhooksManage   = "webhooks:manage"
+)
+
+var allPermissions = []string{
+	PermTodosReadOwn,
+	PermTodosReadAny,
+	PermTodosCreateOwn,
+	PermTodosCreateAny,
+	PermTodosCompleteOwn,
+	PermTodosCompleteAny,
+	PermTodosDeleteOwn,
+	PermTodosDeleteAny,
+	PermUsersRead,
+	PermUsersManage,
+	PermRolesManage,
+	PermWebhooksManage,
+}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	BuiltIn     bool     `json:"built_in"`
+
+	// Overrides of the default rate limits, by class
+	RateLimits map[string]RateLimit `json:"rate_limits,omitempty"`
+}
+
+var (
+	roles   = defaultRoles()
+	rolesMu sync.RWMutex
+)
+
+// The built-in roles reproduce the original admin/user behaviour as the UI and
+// README described it: users work on their own todos but cannot delete them,
+// which only admins could do
+func defaultRoles() map[string]*Role {
+	return map[string]*Role{
+		"admin": {
+			Name:        "admin",
+			Description: "Full access to todos, users and roles",
+			Permissions: append([]string(nil), allPermissions...),
+			BuiltIn:     true,
+		},
+		"user": {
+			Name:        "user",
+			Description: "Works on their own todos and can see the team",
+			Permissions: []string{
+				PermTodosReadOwn,
+				PermTodosReadAny,
+				PermTodosCreateOwn,
+				PermTodosCompleteOwn,
+				PermUsersRead,
+			},
+			BuiltIn: true,
+		},
+	}
+}
+
+// Load extra roles, or overrides of the built-in ones, from the JSON file in ROLES_FILE
+func loadRoles() error {
+	path := os.Getenv("ROLES_FILE")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var fileRoles []Role
+	if err := json.Unmarshal(data, &fileRoles); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	rolesMu.Lock()
+	defer rolesMu.Unlock()
+	for _, role := range fileRoles {
+		if msg := validateRole(role); msg != "" {
+			return fmt.Errorf("%s: role %q: %s", path, role.Name, msg)
+		}
+		role := role
+		if existing, ok := roles[role.Name]; ok {
+			role.BuiltIn = existing.BuiltIn
+		}
+		roles[role.Name] = &role
+	}
+	return nil
+}
+
+func validateRole(role Role) string {
+	if role.Name == "" {
+		return "Role name is required"
+	}
+	if len(role.Name) > 30 {
+		return "Role name must be 30 characters or less"
+	}
+	for _, char := range role.Name {
+		if !((char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '-' || char == '_') {
+			return "Role name can only contain lowercase letters, numbers, '-' and '_'"
+		}
+	}
+	for _, perm := range role.Permissions {
+		if !isKnownPermission(perm) {
+			return fmt.Sprintf("Unknown permission %q", perm)
+		}
+	}
+	for class, limit := range role.RateLimits {
+		if _, ok := defaultRateLimits[class]; !ok {
+			return fmt.Sprintf("Unknown rate limit class %q", class)
+		}
+		if msg := validateRateLimit(limit); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func isKnownPermission(perm string) bool {
+	for _, p := range allPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func roleExists(name string) bool {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	_, ok := roles[name]
+	return ok
+}
+
+// Report whether the named role grants a permission
+func roleHasPermission(roleName, perm string) bool {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+
+	role, ok := roles[roleName]
+	if !ok {
+		return false
+	}
+	for _, p := range role.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func rolePermissions(roleName string) []string {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+
+	role, ok := roles[roleName]
+	if !ok {
+		return []string{}
+	}
+	return append([]string(nil), role.Permissions...)
+}
+
+// Report whether the authenticated caller has a permission
+func hasPermission(r *http.Request, perm string) bool {
+	return roleHasPermission(r.Header.Get("X-User-Role"), perm)
+}
+
+// Check an own/any permission pair against the owner of a todo
+func canActOnTodo(r *http.Request, ownerID int, ownPerm, anyPerm string) bool {
+	if hasPermission(r, anyPerm) {
+		return true
+	}
+	return ownerID == callerID(r) && hasPermission(r, ownPerm)
+}
+
+// ID of the logged-in user, set by authMiddleware
+func callerID(r *http.Request) int {
+	id, _ := strconv.Atoi(r.Header.Get("X-User-ID"))
+	return id
+}
+
+// Permission middleware, used after authMiddleware
+func requirePermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	next = traceHandler(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, span := startSpan(r.Context(), "requirePermission", spanInternal)
+		span.setAttr("auth.permission", perm)
+		allowed := hasPermission(r, perm)
+		span.setAttr("auth.ok", allowed)
+		span.finish()
+		if !allowed {
+			writeError(w, r, http.StatusForbidden, api.CodeForbidden, fmt.Sprintf("Forbidden - %s permission required", perm))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Count users other than excludeID whose role can manage users. Must be
+// called with dataMu held.
+func countUserManagers(excludeID int) int {
+	count := 0
+	for _, u := range users {
+		if u.ID != excludeID && u.DeletedAt == "" && roleHasPermission(u.Role, PermUsersManage) {
+			count++
+		}
+	}
+	return count
+}
+
+func sortedRoles() []Role {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+
+	list := make([]Role, 0, len(roles))
+	for _, role := range roles {
+		list = append(list, *role)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GET /admin/roles — List roles and their permissions
+func getRoles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	json.NewEncoder(w).Encode(sortedRoles())
+}
+
+// GET /admin/permissions — List every known permission
+func getPermissions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	json.NewEncoder(w).Encode(allPermissions)
+}
+
+// POST /admin/roles — Create a custom role
+func createRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var role Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	role.Name = strings.TrimSpace(role.Name)
+	role.BuiltIn = false
+
+	if msg := validateRole(role); msg != "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeValidationFailed, msg)
+		return
+	}
+
+	rolesMu.Lock()
+	if _, ok := roles[role.Name]; ok {
+		rolesMu.Unlock()
+		writeError(w, r, http.StatusConflict, api.CodeConflict, "Role already exists")
+		return
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	roles[role.Name] = &role
+	rolesMu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// PUT /admin/roles/{name} — Replace a role's description and permissions
+func updateRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	name := mux.Vars(r)["name"]
+
+	var update Role
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	update.Name = name
+
+	if msg := validateRole(update); msg != "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeValidationFailed, msg)
+		return
+	}
+
+	// Hold the users still while checking that someone can manage them
+	dataMu.RLock()
+	defer dataMu.RUnlock()
+
+	rolesMu.Lock()
+	role, ok := roles[name]
+	if !ok {
+		rolesMu.Unlock()
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Role not found")
+		return
+	}
+	previous := *role
+	role.Description = update.Description
+	role.Permissions = update.Permissions
+	role.RateLimits = update.RateLimits
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	updated := *role
+	rolesMu.Unlock()
+
+	// Don't let a role change lock everyone out of user management
+	if countUserManagers(0) == 0 {
+		rolesMu.Lock()
+		*role = previous
+		rolesMu.Unlock()
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, fmt.Sprintf("At least one user must keep the %s permission", PermUsersManage))
+		return
+	}
+
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DELETE /admin/roles/{name} — Delete an unused custom role
+func deleteRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	name := mux.Vars(r)["name"]
+
+	dataMu.RLock()
+	defer dataMu.RUnlock()
+	rolesMu.Lock()
+	defer rolesMu.Unlock()
+
+	role, ok := roles[name]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Role not found")
+		return
+	}
+	if role.BuiltIn {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Built-in roles cannot be deleted")
+		return
+	}
+	for _, u := range users {
+		if u.Role == name {
+			writeError(w, r, http.StatusConflict, api.CodeConflict, "Role is still assigned to users")
+			return
+		}
+	}
+
+	delete(roles, name)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role deleted successfully"})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Swap in the built-in roles for the length of a test
+func useDefaultRoles(t *testing.T) {
+	t.Helper()
+	saved := roles
+	t.Cleanup(func() { roles = saved })
+	roles = defaultRoles()
+}
+
+func TestRejectedRoleUpdateChangesNothing(t *testing.T) {
+	useTestData(t)
+	useDefaultRoles(t)
+	useRateLimits(t, map[string]RateLimit{RateLimitAuth: {}, RateLimitRead: {}, RateLimitWrite: {}})
+	router := newRouter()
+
+	login := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"username":"admin","password":"admin"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, login)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d", rec.Code)
+	}
+
+	before := *roles["admin"]
+	// Dropping users:manage from the only role that has it would lock everyone out
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/roles/admin", strings.NewReader(
+		`{"description":"Reads todos","permissions":["todos:read:any","roles:manage"],"rate_limits":{"write":{"per_minute":1,"burst":1}}}`))
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("update: status %d: %s", rec.Code, rec.Body)
+	}
+	if after := *roles["admin"]; !reflect.DeepEqual(after, before) {
+		t.Errorf("role after the rejected update %+v, want %+v", after, before)
+	}
+}
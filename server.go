@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"to-do-list/api"
+)
+
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 60 * time.Second // Long enough for a 10 MB import on a slow link
+	writeTimeout      = 60 * time.Second // The event stream lifts this for itself
+	idleTimeout       = 120 * time.Second
+	maxHeaderBytes    = 64 << 10
+	maxRequestBody    = 1 << 20
+)
+
+// Body limits, in place of maxRequestBody, for routes relative to the API prefix
+var bodyLimits = map[string]int64{
+	"/todos/import":        maxImportSize,
+	"/todos":               maxTodoBodySize,
+	"/todos/{id}/comments": maxTodoBodySize,
+}
+
+var shutdownTimeout = 30 * time.Second
+
+// Background workers (notification scheduler and senders, webhook deliveries,
+// trace exporter) stop when stopWorkers is closed and are waited for on shutdown
+var (
+	stopWorkers = make(chan struct{})
+	workers     sync.WaitGroup
+)
+
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// Load the drain deadline from SHUTDOWN_TIMEOUT, e.g. "30s"
+func loadShutdownTimeout() time.Duration {
+	value := os.Getenv("SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return shutdownTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		slog.Warn("Invalid SHUTDOWN_TIMEOUT", "value", value, "using", shutdownTimeout)
+		return shutdownTimeout
+	}
+	return timeout
+}
+
+// Reject request bodies over the route's limit. Bodies that declare their size
+// get a 413 up front; others fail when the handler reads past the limit.
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(maxRequestBody)
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ := current.GetPathTemplate()
+			if routeLimit, ok := bodyLimits[strings.TrimPrefix(route, api.Prefix)]; ok {
+				limit = routeLimit
+			}
+		}
+		if r.ContentLength > limit {
+			writeError(w, r, http.StatusRequestEntityTooLarge, api.CodePayloadTooLarge, fmt.Sprintf("Request body is larger than %d bytes", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Serve until SIGINT or SIGTERM, then drain in-flight requests and stop the
+// background workers within shutdownTimeout. A second signal exits at once.
+// Servers with a TLSConfig serve HTTPS.
+func serve(servers ...*http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if srv.TLSConfig != nil {
+				serveErr <- srv.ListenAndServeTLS("", "")
+			} else {
+				serveErr <- srv.ListenAndServe()
+			}
+		}(srv)
+	}
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down", "timeout", shutdownTimeout)
+	deadline, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Event streams never go idle on their own, so end them for Shutdown to finish
+	hub.Close()
+	var drained sync.WaitGroup
+	for _, srv := range servers {
+		drained.Add(1)
+		go func(srv *http.Server) {
+			defer drained.Done()
+			if err := srv.Shutdown(deadline); err != nil {
+				slog.Warn("Requests still running at the shutdown deadline were cut off", "addr", srv.Addr, "error", err)
+				srv.Close()
+			}
+		}(srv)
+	}
+	drained.Wait()
+
+	close(stopWorkers)
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-deadline.Done():
+		return errors.New("background workers did not stop before the shutdown deadline")
+	}
+
+	slog.Info("Server stopped")
+	return nil
+}
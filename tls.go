@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often a handshake may trigger a check of the certificate files
+const certCheckInterval = time.Second
+
+// Address and URL of the plain HTTP server, or of the HTTPS server when TLS is on
+var (
+	httpAddr      = ":8080"
+	httpsAddr     = ":8443"
+	serverBaseURL = "http://localhost:8080"
+)
+
+// Serves the certificate from certFile/keyFile, reloading the pair when either
+// file changes. A pair that fails to load, e.g. while only one file has been
+// replaced, is logged and the previous certificate is kept.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.certModTime = certInfo.ModTime()
+	c.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+// Report whether either file's modification time differs from the loaded pair
+func (c *certReloader) changed() bool {
+	certInfo, certErr := os.Stat(c.certFile)
+	keyInfo, keyErr := os.Stat(c.keyFile)
+	if certErr != nil || keyErr != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(c.certModTime) || !keyInfo.ModTime().Equal(c.keyModTime)
+}
+
+// tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) >= certCheckInterval {
+		c.lastCheck = time.Now()
+		if c.changed() {
+			if err := c.reload(); err != nil {
+				slog.Warn("Reloading TLS certificate failed, keeping the previous one", "cert", c.certFile, "error", err)
+			} else {
+				slog.Info("Reloaded TLS certificate", "cert", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// Load TLS settings from TLS_CERT_FILE and TLS_KEY_FILE. When both are set the
+// app is served over HTTPS on TLS_ADDR (default :8443), plain HTTP requests are
+// redirected there and the session cookie is marked Secure. It returns nil
+// when TLS is off.
+func loadTLSConfig() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if addr := os.Getenv("TLS_ADDR"); addr != "" {
+		httpsAddr = addr
+	}
+	serverBaseURL = "https://localhost" + portSuffix(httpsAddr, "443")
+	store.Options.Secure = true
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// ":port" for addr, or "" when it is the scheme's default port
+func portSuffix(addr, defaultPort string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" || port == defaultPort {
+		return ""
+	}
+	return ":" + port
+}
+
+// Send plain HTTP requests to the same host and path on the HTTPS server
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	target := "https://" + host + portSuffix(httpsAddr, "443") + r.URL.RequestURI()
+	// 308 keeps the method and body of API calls
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a self-signed certificate for localhost with the given common name,
+// returning the PEM-encoded certificate so clients can trust it
+func writeSelfSigned(t *testing.T, certFile, keyFile, commonName string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+func servedCommonName(t *testing.T, c *certReloader) string {
+	t.Helper()
+	cert, err := c.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// Move both files' modification times forward so the change is seen even on
+// file systems with coarse timestamps, and let the next handshake check them
+func touch(t *testing.T, c *certReloader, files ...string) {
+	t.Helper()
+	later := time.Now().Add(time.Minute)
+	for _, f := range files {
+		if err := os.Chtimes(f, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.mu.Lock()
+	c.lastCheck = time.Time{}
+	c.mu.Unlock()
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile, "first")
+
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedCommonName(t, c); got != "first" {
+		t.Fatalf("serving %q, want first", got)
+	}
+
+	writeSelfSigned(t, certFile, keyFile, "second")
+	touch(t, c, certFile, keyFile)
+	if got := servedCommonName(t, c); got != "second" {
+		t.Fatalf("after replacing the pair, serving %q, want second", got)
+	}
+
+	// A certificate without its matching key must not replace the working pair
+	otherCert, otherKey := filepath.Join(dir, "other.pem"), filepath.Join(dir, "other-key.pem")
+	writeSelfSigned(t, otherCert, otherKey, "third")
+	data, err := os.ReadFile(otherCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, c, certFile)
+	if got := servedCommonName(t, c); got != "second" {
+		t.Fatalf("after a mismatched certificate, serving %q, want second", got)
+	}
+}
+
+func TestLoadTLSConfigRequiresBothFiles(t *testing.T) {
+	t.Setenv("TLS_CERT_FILE", filepath.Join(t.TempDir(), "cert.pem"))
+	t.Setenv("TLS_KEY_FILE", "")
+	if _, err := loadTLSConfig(); err == nil {
+		t.Fatal("expected an error with only TLS_CERT_FILE set")
+	}
+}
+
+func TestServeOverTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := writeSelfSigned(t, certFile, keyFile, "localhost")
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+
+	secure, base := store.Options.Secure, serverBaseURL
+	t.Cleanup(func() { store.Options.Secure, serverBaseURL = secure, base })
+
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.Options.Secure {
+		t.Error("session cookie is not marked Secure with TLS on")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(ln.Addr().String(), newRouter())
+	srv.TLSConfig = config
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	resp, err := client.Get("https://localhost:" + portOf(t, ln.Addr()) + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /healthz over TLS: status %d", resp.StatusCode)
+	}
+	if resp.TLS == nil || resp.TLS.PeerCertificates[0].Subject.CommonName != "localhost" {
+		t.Fatal("response was not served with the configured certificate")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	addr := httpsAddr
+	t.Cleanup(func() { httpsAddr = addr })
+
+	tests := []struct {
+		httpsAddr, url, want string
+	}{
+		{":8443", "http://example.com:8080/api/v1/todos?user=alice", "https://example.com:8443/api/v1/todos?user=alice"},
+		{":443", "http://example.com/", "https://example.com/"},
+		{":8443", "http://[::1]:8080/healthz", "https://[::1]:8443/healthz"},
+	}
+	for _, tt := range tests {
+		httpsAddr = tt.httpsAddr
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(rec, httptest.NewRequest(http.MethodPost, tt.url, nil))
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: status %d, want 308", tt.url, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: Location %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func portOf(t *testing.T, addr net.Addr) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
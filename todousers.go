@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"to-do-list/api"
+)
+
+// Todos refer to their assignee and creator by user ID. The usernames in
+// Todo.User and Todo.CreatedBy and the Assignee profile are copies, refreshed
+// from the users table whenever the todo or the user changes.
+
+var errUnknownUser = errors.New("no such user")
+
+// The user with this ID, trashed or not
+func findAnyUserByID(id int) *User {
+	for i, u := range users {
+		if u.ID == id {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+func todoUser(user *User) *api.TodoUser {
+	return &api.TodoUser{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		AvatarURL:   user.AvatarURL,
+	}
+}
+
+// Assign a todo to a user. Todos can only reference users that exist.
+func linkTodoUser(todo *Todo, userID int) error {
+	user := findAnyUserByID(userID)
+	if user == nil {
+		return fmt.Errorf("%w with ID %d", errUnknownUser, userID)
+	}
+	assignTodo(todo, user)
+	return nil
+}
+
+func assignTodo(todo *Todo, user *User) {
+	todo.UserID = user.ID
+	todo.User = user.Username
+	todo.Assignee = todoUser(user)
+}
+
+// Record who created a todo. 0 is a todo created by no one, e.g. sample data.
+func linkTodoCreator(todo *Todo, userID int) error {
+	if userID == 0 {
+		todo.CreatedByID, todo.CreatedBy = 0, ""
+		return nil
+	}
+	user := findAnyUserByID(userID)
+	if user == nil {
+		return fmt.Errorf("%w with ID %d", errUnknownUser, userID)
+	}
+	todo.CreatedByID = user.ID
+	todo.CreatedBy = user.Username
+	return nil
+}
+
+// Give a new todo the next ID and add it to the store, after checking that the
+// users it references exist
+func insertTodo(ctx context.Context, todo Todo) (Todo, error) {
+	inserted, err := insertTodos(ctx, []Todo{todo})
+	if err != nil {
+		return Todo{}, err
+	}
+	return inserted[0], nil
+}
+
+// Add several new todos at once. Every todo's users are checked first, so
+// either all of them are added or, on an error, none are.
+func insertTodos(ctx context.Context, list []Todo) ([]Todo, error) {
+	s := startStoreSpan(ctx, "insertTodos")
+	s.setAttr("todo.count", len(list))
+	defer s.finish()
+
+	linked := make([]Todo, len(list))
+	for i, todo := range list {
+		if err := linkTodoUser(&todo, todo.UserID); err != nil {
+			s.setError(err.Error())
+			return nil, err
+		}
+		if err := linkTodoCreator(&todo, todo.CreatedByID); err != nil {
+			s.setError(err.Error())
+			return nil, err
+		}
+		linked[i] = todo
+	}
+	for i := range linked {
+		linked[i].ID = nextID
+		nextID++
+	}
+	todos = append(todos, linked...)
+	return linked, nil
+}
+
+// Refresh the copies of a user's details on the todos that reference them,
+// after a rename or profile change. Returns the todos assigned to the user.
+func refreshUserTodos(user *User) []Todo {
+	var assigned []Todo
+	for i := range todos {
+		if todos[i].UserID == user.ID {
+			assignTodo(&todos[i], user)
+			assigned = append(assigned, todos[i])
+		}
+		if todos[i].CreatedByID == user.ID {
+			todos[i].CreatedBy = user.Username
+		}
+	}
+	return assigned
+}
+
+// Remove a user for good, along with the todos assigned to them, the comments
+// on those todos and the comments they wrote. Todos they created for other
+// users are kept without a creator.
+func purgeUserRecord(userID int) {
+	var remainingTodos []Todo
+	purged := make(map[int]bool)
+	for _, todo := range todos {
+		if todo.UserID == userID {
+			purged[todo.ID] = true
+			continue
+		}
+		if todo.CreatedByID == userID {
+			todo.CreatedByID, todo.CreatedBy = 0, ""
+		}
+		remainingTodos = append(remainingTodos, todo)
+	}
+	todos = remainingTodos
+	purgeTodoComments(purged)
+	purgeUserComments(userID)
+
+	for i, u := range users {
+		if u.ID == userID {
+			users = append(users[:i], users[i+1:]...)
+			break
+		}
+	}
+}
+
+// Convert todos that name their users, as all todos did before they held user
+// IDs, to reference the users by ID. Names are matched like logins, regardless
+// of case. Fails, listing the todos, if any name matches no user.
+func migrateTodoUsers() error {
+	var orphans []string
+	for i := range todos {
+		todo := &todos[i]
+		if todo.UserID == 0 {
+			if user := usernameOwner(todo.User, 0); user != nil {
+				todo.UserID = user.ID
+			}
+		}
+		if err := linkTodoUser(todo, todo.UserID); err != nil {
+			orphans = append(orphans, fmt.Sprintf("todo %d (user %q)", todo.ID, todo.User))
+			continue
+		}
+		if todo.CreatedByID == 0 && todo.CreatedBy != "" {
+			if user := usernameOwner(todo.CreatedBy, 0); user != nil {
+				todo.CreatedByID = user.ID
+			} else {
+				orphans = append(orphans, fmt.Sprintf("todo %d (created by %q)", todo.ID, todo.CreatedBy))
+				continue
+			}
+		}
+		if err := linkTodoCreator(todo, todo.CreatedByID); err != nil {
+			orphans = append(orphans, fmt.Sprintf("todo %d (created by user %d)", todo.ID, todo.CreatedByID))
+		}
+	}
+	if len(orphans) > 0 {
+		return fmt.Errorf("%w: %s", errUnknownUser, strings.Join(orphans, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInsertTodosIsAllOrNothing(t *testing.T) {
+	useTestData(t)
+
+	_, err := insertTodos(context.Background(), []Todo{{Text: "ok", UserID: 2}, {Text: "orphan", UserID: 99}})
+	if !errors.Is(err, errUnknownUser) {
+		t.Fatalf("error %v, want errUnknownUser", err)
+	}
+	if len(todos) != 0 || nextID != 1 {
+		t.Fatalf("%d todos saved and next ID %d after a failed insert, want none and 1", len(todos), nextID)
+	}
+
+	inserted, err := insertTodos(context.Background(), []Todo{{Text: "one", UserID: 2, CreatedByID: 1}, {Text: "two", UserID: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todos) != 2 || inserted[0].ID != 1 || inserted[1].ID != 2 {
+		t.Fatalf("inserted %+v", inserted)
+	}
+	if inserted[0].User != "alice" || inserted[0].CreatedBy != "admin" || inserted[1].Assignee.Username != "bob" {
+		t.Fatalf("users not linked: %+v", inserted)
+	}
+}
+
+// Purging takes the purged todos' comments with them, whichever way the todos go
+func TestPurgedTodosLoseTheirComments(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	longAgo := time.Now().Add(-2 * trashRetention).Format(timestampFormat)
+	todos = []Todo{
+		{ID: 1, Text: "Expired in the trash", UserID: 2, DeletedAt: longAgo},
+		{ID: 2, Text: "Purged by hand", UserID: 2, DeletedAt: time.Now().Format(timestampFormat)},
+		{ID: 3, Text: "Belongs to a purged user", UserID: 3},
+		{ID: 4, Text: "Kept", UserID: 4},
+	}
+	for _, id := range []int{1, 2, 3, 4} {
+		comments = append(comments, Comment{ID: id, TodoID: id, UserID: 1, Text: "Note"})
+	}
+	remaining := func() []int {
+		var ids []int
+		for _, c := range comments {
+			ids = append(ids, c.TodoID)
+		}
+		return ids
+	}
+
+	purgeExpiredTrash(context.Background())
+	if got := remaining(); !reflect.DeepEqual(got, []int{2, 3, 4}) {
+		t.Errorf("after the trash expired, comments on todos %v", got)
+	}
+
+	router := newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"username":"admin","password":"admin"}`)))
+	purge := httptest.NewRequest(http.MethodDelete, "/api/v1/todos/trash/2", nil)
+	for _, c := range rec.Result().Cookies() {
+		purge.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, purge)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("purge: status %d: %s", rec.Code, rec.Body)
+	}
+	purgeUserRecord(3)
+	if got := remaining(); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("after purging a todo and a user, comments on todos %v", got)
+	}
+}
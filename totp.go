@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+
+	"to-do-list/api"
+)
+
+const (
+	totpIssuer         = "Team To-Do"
+	totpPeriod         = 30
+	totpDigits         = 6
+	totpSkew           = 1 // Accept codes from one step before/after to allow for clock drift
+	recoveryCodeCount  = 10
+	pendingLoginTTL    = 5 * time.Minute
+	maxSecondFactorTry = 5
+	secondFactorLock   = 15 * time.Minute // How long a user is locked out after maxSecondFactorTry wrong codes
+	reauthWindow       = 5 * time.Minute
+)
+
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Wrong second factors since a user's last successful one
+type secondFactorFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// Failed second factors by user ID. They are kept here rather than in the
+// session cookie so that replaying an older cookie does not reset the count.
+// Guarded by dataMu.
+var secondFactorAttempts = make(map[int]*secondFactorFailures)
+
+// Count a wrong second factor. Every failure from the maxSecondFactorTry-th on
+// locks the user out for secondFactorLock.
+func recordSecondFactorFailure(userID int) {
+	failures := secondFactorAttempts[userID]
+	if failures == nil {
+		failures = &secondFactorFailures{}
+		secondFactorAttempts[userID] = failures
+	}
+	failures.count++
+	if failures.count >= maxSecondFactorTry {
+		failures.lockedUntil = time.Now().Add(secondFactorLock)
+	}
+}
+
+// How much longer the user is locked out of the second login step, or 0
+func secondFactorLockout(userID int) time.Duration {
+	if failures := secondFactorAttempts[userID]; failures != nil {
+		if wait := time.Until(failures.lockedUntil); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+type TwoFactorRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TwoFactorPolicyRequest struct {
+	Required bool `json:"required"`
+}
+
+func generateTOTPSecret() string {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return base32NoPad.EncodeToString(secret)
+}
+
+// Compute the RFC 6238 code for a time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32NoPad.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// Check a code against the secret and return the matched time step. Steps at or
+// before lastStep are rejected so a code cannot be replayed.
+func verifyTOTP(secret, code string, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpProvisioningURI(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate fresh recovery codes; only their hashes are kept on the user
+func generateRecoveryCodes(user *User) []string {
+	codes := make([]string, recoveryCodeCount)
+	user.RecoveryCodes = make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			panic(err)
+		}
+		code := base32NoPad.EncodeToString(raw)
+		codes[i] = code[:4] + "-" + code[4:]
+		user.RecoveryCodes[i] = hashRecoveryCode(code)
+	}
+	return codes
+}
+
+// Consume a recovery code if it matches one of the user's remaining codes
+func useRecoveryCode(user *User, code string) bool {
+	hashed := hashRecoveryCode(code)
+	for i, stored := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hashed)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Verify a second factor, accepting either a TOTP code or a recovery code
+func verifySecondFactor(user *User, code, recoveryCode string) bool {
+	if recoveryCode != "" {
+		return useRecoveryCode(user, recoveryCode)
+	}
+	step, ok := verifyTOTP(user.TOTPSecret, code, user.TOTPLastStep)
+	if ok {
+		user.TOTPLastStep = step
+	}
+	return ok
+}
+
+// Re-authenticate for sensitive 2FA changes. Accounts with a password must supply
+// it; SSO-only accounts must have signed in within the last few minutes.
+func verifyReauth(session *sessions.Session, user *User, password string) bool {
+	if user.Password != "" {
+		return subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) == 1
+	}
+	authTime, _ := session.Values["auth_time"].(int64)
+	return time.Since(time.Unix(authTime, 0)) < reauthWindow
+}
+
+// The active user with this ID
+func findUserByID(ctx context.Context, id int) *User {
+	defer startStoreSpan(ctx, "findUserByID").finish()
+	for i, u := range users {
+		if u.ID == id && u.DeletedAt == "" {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// Log in a user once their primary credential is verified. If they have 2FA
+// enabled the session only records a pending login until the second step passes.
+// Returns true when a second factor is still needed.
+func beginLogin(session *sessions.Session, user *User) bool {
+	if user.TOTPEnabled {
+		delete(session.Values, "user_id")
+		delete(session.Values, "username")
+		delete(session.Values, "role")
+		session.Values["pending_user_id"] = user.ID
+		session.Values["pending_at"] = time.Now().Unix()
+		return true
+	}
+
+	setSessionUser(session, user)
+	return false
+}
+
+// POST /login/2fa — Second login step
+func loginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req api.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	session, err := store.Get(r, "todo-session")
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Session error")
+		return
+	}
+
+	userID, ok := session.Values["pending_user_id"].(int)
+	pendingAt, _ := session.Values["pending_at"].(int64)
+	if !ok || time.Since(time.Unix(pendingAt, 0)) > pendingLoginTTL {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "No pending login, please log in again")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	user := findUserByID(r.Context(), userID)
+	if user == nil || !user.TOTPEnabled {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "No pending login, please log in again")
+		return
+	}
+
+	if wait := secondFactorLockout(user.ID); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeError(w, r, http.StatusTooManyRequests, api.CodeRateLimited, "Too many invalid authentication codes, try again later")
+		return
+	}
+
+	if !verifySecondFactor(user, req.Code, req.RecoveryCode) {
+		recordSecondFactorFailure(user.ID)
+		recordLogin("two_factor", false)
+		writeError(w, r, http.StatusUnauthorized, api.CodeInvalidCredentials, "Invalid authentication code")
+		return
+	}
+
+	delete(secondFactorAttempts, user.ID)
+	delete(session.Values, "pending_user_id")
+	delete(session.Values, "pending_at")
+	setSessionUser(session, user)
+
+	if err := session.Save(r, w); err != nil {
+		writeError(w, r, http.StatusInternalServerError, api.CodeInternalError, "Session error")
+		return
+	}
+
+	recordLogin("two_factor", true)
+	response := loginResponse(user)
+	remaining := len(user.RecoveryCodes)
+	response.RecoveryCodesRemaining = &remaining
+	json.NewEncoder(w).Encode(response)
+}
+
+// Load the session and the user it belongs to for the 2FA management endpoints.
+// Must be called with dataMu held.
+func currentSessionUser(w http.ResponseWriter, r *http.Request) (*sessions.Session, *User, bool) {
+	session, err := store.Get(r, "todo-session")
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Session error")
+		return nil, nil, false
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Not logged in")
+		return nil, nil, false
+	}
+
+	user := findUserByID(r.Context(), userID)
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "User not found")
+		return nil, nil, false
+	}
+	if !sessionIsCurrent(session, user) {
+		writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Not logged in")
+		return nil, nil, false
+	}
+	return session, user, true
+}
+
+// POST /2fa/setup — Start enrollment and return the provisioning URI
+func setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	session, user, ok := currentSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		writeError(w, r, http.StatusConflict, api.CodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if !verifyReauth(session, user, req.Password) {
+		writeError(w, r, http.StatusUnauthorized, api.CodeInvalidCredentials, "Re-authentication failed")
+		return
+	}
+
+	user.TOTPPendingSecret = generateTOTPSecret()
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      user.TOTPPendingSecret,
+		"otpauth_url": totpProvisioningURI(user.Username, user.TOTPPendingSecret),
+	})
+}
+
+// POST /2fa/enable — Confirm enrollment with a code from the authenticator app
+func enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	_, user, ok := currentSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.TOTPPendingSecret == "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Start two-factor setup first")
+		return
+	}
+
+	step, valid := verifyTOTP(user.TOTPPendingSecret, req.Code, 0)
+	if !valid {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidCredentials, "Invalid authentication code")
+		return
+	}
+
+	user.TOTPSecret = user.TOTPPendingSecret
+	user.TOTPPendingSecret = ""
+	user.TOTPLastStep = step
+	user.TOTPEnabled = true
+	codes := generateRecoveryCodes(user)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// POST /2fa/disable — Turn off 2FA after re-authenticating with password and a code
+func disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	session, user, ok := currentSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+	if user.TOTPRequired {
+		writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Two-factor authentication is required for this account")
+		return
+	}
+	if !verifyReauth(session, user, req.Password) || !verifySecondFactor(user, req.Code, req.RecoveryCode) {
+		writeError(w, r, http.StatusUnauthorized, api.CodeInvalidCredentials, "Re-authentication failed")
+		return
+	}
+
+	resetTwoFactor(user)
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// POST /2fa/recovery-codes — Replace the recovery codes
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	session, user, ok := currentSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+	if !verifyReauth(session, user, req.Password) || !verifySecondFactor(user, req.Code, "") {
+		writeError(w, r, http.StatusUnauthorized, api.CodeInvalidCredentials, "Re-authentication failed")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": generateRecoveryCodes(user),
+	})
+}
+
+func resetTwoFactor(user *User) {
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPPendingSecret = ""
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+}
+
+// PUT /admin/users/{id}/2fa — Require (or stop requiring) 2FA for a user
+func setTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid user ID")
+		return
+	}
+
+	var req TwoFactorPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	user := findUserByID(r.Context(), userID)
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "User not found")
+		return
+	}
+
+	user.TOTPRequired = req.Required
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Two-factor policy updated",
+		"totp_required": user.TOTPRequired,
+		"totp_enabled":  user.TOTPEnabled,
+	})
+}
+
+// DELETE /admin/users/{id}/2fa — Reset a user's 2FA, e.g. after a lost device
+func resetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid user ID")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	user := findUserByID(r.Context(), userID)
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "User not found")
+		return
+	}
+
+	resetTwoFactor(user)
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication reset"})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors from RFC 6238 appendix B, for the secret
+// "12345678901234567890". The RFC lists eight digits; six are their last six.
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	secret := base32NoPad.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := totpCode(secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("an invalid secret was accepted")
+	}
+}
+
+func currentTOTPCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totpCode(secret, time.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPCodeCannotBeReused(t *testing.T) {
+	user := &User{TOTPEnabled: true, TOTPSecret: generateTOTPSecret()}
+	code := currentTOTPCode(t, user.TOTPSecret)
+
+	if !verifySecondFactor(user, code, "") {
+		t.Fatal("the current code was rejected")
+	}
+	if user.TOTPLastStep == 0 {
+		t.Fatal("the used time step was not recorded")
+	}
+	if verifySecondFactor(user, code, "") {
+		t.Error("the same code was accepted twice")
+	}
+	if verifySecondFactor(user, "12345", "") || verifySecondFactor(user, "", "") {
+		t.Error("a malformed code was accepted")
+	}
+}
+
+func TestRecoveryCodeWorksOnce(t *testing.T) {
+	user := &User{TOTPEnabled: true, TOTPSecret: generateTOTPSecret()}
+	codes := generateRecoveryCodes(user)
+	if len(codes) != recoveryCodeCount || len(user.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("%d codes given and %d kept, want %d", len(codes), len(user.RecoveryCodes), recoveryCodeCount)
+	}
+	for _, hashed := range user.RecoveryCodes {
+		for _, code := range codes {
+			if strings.Contains(hashed, strings.ReplaceAll(code, "-", "")) {
+				t.Fatal("a recovery code is stored in plain text")
+			}
+		}
+	}
+
+	if !verifySecondFactor(user, "", codes[0]) {
+		t.Fatal("a fresh recovery code was rejected")
+	}
+	if verifySecondFactor(user, "", codes[0]) {
+		t.Error("a recovery code was accepted twice")
+	}
+	// Typed without the dash and in lower case
+	if !verifySecondFactor(user, "", strings.ToLower(strings.ReplaceAll(codes[1], "-", ""))) {
+		t.Error("a recovery code typed without the dash was rejected")
+	}
+	if len(user.RecoveryCodes) != recoveryCodeCount-2 {
+		t.Errorf("%d codes left, want %d", len(user.RecoveryCodes), recoveryCodeCount-2)
+	}
+}
+
+// Start a login for a user with 2FA and return the cookies of the pending login
+func beginTwoFactorLogin(t *testing.T, handler http.Handler, username, password string) []*http.Cookie {
+	t.Helper()
+	rec := doJSON(t, handler, http.MethodPost, "/api/v1/login", `{"username":"`+username+`","password":"`+password+`"}`, nil)
+	var resp struct {
+		TwoFactorRequired bool `json:"two_factor_required"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || !resp.TwoFactorRequired {
+		t.Fatalf("login as %s: status %d, two factor required %v", username, rec.Code, resp.TwoFactorRequired)
+	}
+	return rec.Result().Cookies()
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	t.Cleanup(func() { delete(secondFactorAttempts, 2) })
+	router := newRouter()
+	users[1].TOTPEnabled, users[1].TOTPSecret = true, generateTOTPSecret()
+
+	pending := beginTwoFactorLogin(t, router, "alice", "password123")
+	for i := 1; i < maxSecondFactorTry; i++ {
+		if rec := doJSON(t, router, http.MethodPost, "/api/v1/login/2fa", `{"code":"000000"}`, pending); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: status %d, want 401", i, rec.Code)
+		}
+	}
+	// A new login does not reset the count
+	pending = beginTwoFactorLogin(t, router, "alice", "password123")
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/login/2fa", `{"code":"000000"}`, pending); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("last wrong code: status %d, want 401", rec.Code)
+	}
+
+	code := currentTOTPCode(t, users[1].TOTPSecret)
+	rec := doJSON(t, router, http.MethodPost, "/api/v1/login/2fa", `{"code":"`+code+`"}`, pending)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("right code while locked out: status %d, Retry-After %q; want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Once the lockout has passed the right code logs in and clears the count
+	secondFactorAttempts[2].lockedUntil = time.Now().Add(-time.Second)
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/login/2fa", `{"code":"`+code+`"}`, pending); rec.Code != http.StatusOK {
+		t.Fatalf("right code after the lockout: status %d: %s", rec.Code, rec.Body)
+	}
+	if _, counted := secondFactorAttempts[2]; counted {
+		t.Error("failed attempts are still counted after a successful login")
+	}
+}
+
+func TestEnrollmentTakesEffectOnceConfirmed(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	router := newRouter()
+	alice := logIn(t, router, "alice", "password123")
+
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/2fa/setup", `{"password":"wrong"}`, alice); rec.Code == http.StatusOK {
+		t.Fatal("setup without re-authenticating succeeded")
+	}
+	rec := doJSON(t, router, http.MethodPost, "/api/v1/2fa/setup", `{"password":"password123"}`, alice)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("setup: status %d: %s", rec.Code, rec.Body)
+	}
+	var setup struct {
+		Secret     string `json:"secret"`
+		OTPAuthURL string `json:"otpauth_url"`
+	}
+	json.NewDecoder(rec.Body).Decode(&setup)
+	if setup.Secret == "" || !strings.HasPrefix(setup.OTPAuthURL, "otpauth://totp/") {
+		t.Fatalf("setup response %+v", setup)
+	}
+
+	// Until it is confirmed, logging in takes the password alone
+	if users[1].TOTPEnabled {
+		t.Fatal("2FA is enabled before the code is confirmed")
+	}
+	logIn(t, router, "alice", "password123")
+
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/2fa/enable", `{"code":"000000"}`, alice); rec.Code != http.StatusBadRequest || users[1].TOTPEnabled {
+		t.Fatalf("enabling with a wrong code: status %d, enabled %v", rec.Code, users[1].TOTPEnabled)
+	}
+	rec = doJSON(t, router, http.MethodPost, "/api/v1/2fa/enable", `{"code":"`+currentTOTPCode(t, setup.Secret)+`"}`, alice)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enabling: status %d: %s", rec.Code, rec.Body)
+	}
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.NewDecoder(rec.Body).Decode(&enabled)
+	if len(enabled.RecoveryCodes) != recoveryCodeCount || !users[1].TOTPEnabled || users[1].TOTPPendingSecret != "" {
+		t.Fatalf("after enabling: %d recovery codes, enabled %v", len(enabled.RecoveryCodes), users[1].TOTPEnabled)
+	}
+	beginTwoFactorLogin(t, router, "alice", "password123")
+}
+
+// A requirement an admin turns on applies to sessions that were opened before it
+func TestTwoFactorRequirementAppliesToOpenSessions(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	router := newRouter()
+	admin := logIn(t, router, "admin", "admin")
+	alice := logIn(t, router, "alice", "password123")
+
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/todos", "", alice); rec.Code != http.StatusOK {
+		t.Fatalf("before the requirement: status %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodPut, "/api/v1/admin/users/2/2fa", `{"required":true}`, admin); rec.Code != http.StatusOK {
+		t.Fatalf("requiring 2FA: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/todos", "", alice); rec.Code != http.StatusForbidden {
+		t.Fatalf("open session after the requirement: status %d, want 403", rec.Code)
+	}
+	// Enrollment stays reachable
+	if rec := doJSON(t, router, http.MethodPost, "/api/v1/2fa/setup", `{"password":"password123"}`, alice); rec.Code != http.StatusOK {
+		t.Fatalf("starting enrollment: status %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := doJSON(t, router, http.MethodPut, "/api/v1/admin/users/2/2fa", `{"required":false}`, admin); rec.Code != http.StatusOK {
+		t.Fatalf("lifting the requirement: status %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/api/v1/todos", "", alice); rec.Code != http.StatusOK {
+		t.Fatalf("after lifting the requirement: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// OpenTelemetry span kinds
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+const (
+	traceQueueSize     = 2048
+	traceBatchSize     = 512
+	traceFlushInterval = 2 * time.Second
+)
+
+// Ended spans waiting for the exporter; nil while tracing is off
+var traceQueue chan *span
+
+var serviceName = "todo-server"
+
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // Zero for a root span
+	sampled  bool
+
+	name       string
+	kind       int
+	start, end time.Time
+	attrs      []spanAttr
+	failed     bool
+	statusMsg  string
+}
+
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+type spanKey struct{}
+
+// Set up tracing from the standard OpenTelemetry variables. OTEL_TRACES_EXPORTER
+// is "none" (default), "stdout", "file" (OTEL_TRACES_FILE, default traces.jsonl)
+// or "otlp" (OTLP/HTTP JSON to OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or
+// OTEL_EXPORTER_OTLP_ENDPOINT plus /v1/traces).
+func loadTracing() {
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		serviceName = name
+	}
+
+	var export func([]*span) error
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "", "none":
+		return
+	case "stdout":
+		export = writerExporter(os.Stdout)
+	case "file":
+		path := os.Getenv("OTEL_TRACES_FILE")
+		if path == "" {
+			path = "traces.jsonl"
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			slog.Warn("Tracing disabled, could not open trace file", "path", path, "error", err)
+			return
+		}
+		export = writerExporter(f)
+	case "otlp":
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+		if endpoint == "" {
+			base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+			if base == "" {
+				base = "http://localhost:4318"
+			}
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+		export = otlpExporter(endpoint, parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")))
+	default:
+		slog.Warn("Invalid OTEL_TRACES_EXPORTER, tracing disabled", "value", exporter)
+		return
+	}
+
+	traceQueue = make(chan *span, traceQueueSize)
+	workers.Add(1)
+	go runTraceExporter(export)
+	slog.Info("Tracing enabled", "exporter", os.Getenv("OTEL_TRACES_EXPORTER"), "service", serviceName)
+}
+
+// Start a span as a child of the span in ctx, or as a new trace. The returned
+// span is nil when tracing is off; its methods accept a nil receiver.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if traceQueue == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), sampled: true}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID, s.parentID, s.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+func (s *span) setAttr(key string, value interface{}) {
+	if s != nil {
+		s.attrs = append(s.attrs, spanAttr{key, value})
+	}
+}
+
+func (s *span) setError(message string) {
+	if s != nil {
+		s.failed, s.statusMsg = true, message
+	}
+}
+
+// Finish the span and queue it for export, dropping it if the exporter is behind
+func (s *span) finish() {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	if !s.sampled {
+		return
+	}
+	select {
+	case traceQueue <- s:
+	default:
+	}
+}
+
+func (s *span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", s.traceID, s.spanID, flags)
+}
+
+// Read a W3C traceparent header: version-traceid-parentid-flags
+func parseTraceparent(value string) (traceID [16]byte, parentID [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return traceID, parentID, false, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags&1 == 1, true
+}
+
+// Start a span for a call into the in-memory store, named store.<operation>.
+// Store calls made outside a traced request, e.g. by the scheduler, are not recorded.
+func startStoreSpan(ctx context.Context, operation string) *span {
+	if spanFromContext(ctx) == nil {
+		return nil
+	}
+	_, s := startSpan(ctx, "store."+operation, spanInternal)
+	s.setAttr("db.system", "memory")
+	s.setAttr("db.operation.name", operation)
+	return s
+}
+
+// Pass the span in ctx on to an outgoing request
+func injectTraceContext(ctx context.Context, header http.Header) {
+	if s := spanFromContext(ctx); s != nil {
+		header.Set("traceparent", s.traceparent())
+	}
+}
+
+// Start a server span for every request, continuing the caller's trace when it
+// sent a traceparent header
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if traceQueue == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		name := r.Method
+		if route != "" {
+			name += " " + route
+		}
+
+		ctx, s := startSpan(r.Context(), name, spanServer)
+		if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			s.traceID, s.parentID, s.sampled = traceID, parentID, sampled
+		}
+		s.setAttr("http.request.method", r.Method)
+		s.setAttr("url.path", r.URL.Path)
+		if route != "" {
+			s.setAttr("http.route", route)
+		}
+		if agent := r.UserAgent(); agent != "" {
+			s.setAttr("user_agent.original", agent)
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			s.setAttr("http.response.status_code", rec.status)
+			if user := r.Header.Get("X-User-Name"); user != "" {
+				s.setAttr("enduser.id", user)
+			}
+			if rec.status >= 500 {
+				s.setError(http.StatusText(rec.status))
+			}
+			s.finish()
+		}()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+	})
+}
+
+// Wrap a named handler function in a span named after it. Closures, such as the
+// ones returned by the middlewares, are passed through so every handler gets one span.
+func traceHandler(next http.HandlerFunc) http.HandlerFunc {
+	name := runtime.FuncForPC(reflect.ValueOf(next).Pointer()).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	_, name, _ = strings.Cut(name, ".") // Drop the package name, main or the module path in tests
+	if strings.Contains(name, ".") {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, s := startSpan(r.Context(), name, spanInternal)
+		s.setAttr("code.function", name)
+		defer s.finish()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// Collect ended spans into batches for the exporter. On shutdown the spans
+// still queued are exported before it returns.
+func runTraceExporter(export func([]*span) error) {
+	defer workers.Done()
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := export(batch); err != nil {
+			slog.Warn("Exporting spans failed", "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-traceQueue:
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-stopWorkers:
+			for {
+				select {
+				case s := <-traceQueue:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Write each batch as one line of OTLP JSON, the format of the collector's file exporter
+func writerExporter(w io.Writer) func([]*span) error {
+	return func(batch []*span) error {
+		return json.NewEncoder(w).Encode(otlpRequest(batch))
+	}
+}
+
+var otlpClient = &http.Client{Timeout: 10 * time.Second}
+
+func otlpExporter(endpoint string, headers map[string]string) func([]*span) error {
+	return func(batch []*span) error {
+		body, err := json.Marshal(otlpRequest(batch))
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := otlpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return errors.New("collector responded with " + resp.Status)
+		}
+		return nil
+	}
+}
+
+// OTEL_EXPORTER_OTLP_HEADERS is a list of key=value pairs separated by commas
+func parseOTLPHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(key) != "" {
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+	}
+	return headers
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+// The body of an OTLP ExportTraceServiceRequest in its JSON encoding
+func otlpRequest(batch []*span) map[string]interface{} {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attrs {
+			out.Attributes = append(out.Attributes, otlpAttr(attr.key, attr.value))
+		}
+		if s.failed {
+			out.Status.Code = 2
+			out.Status.Message = s.statusMsg
+		}
+		spans[i] = out
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{otlpAttr("service.name", serviceName)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "to-do-list"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	switch v := value.(type) {
+	case int:
+		return otlpAttribute{key, map[string]interface{}{"intValue": strconv.Itoa(v)}}
+	case bool:
+		return otlpAttribute{key, map[string]interface{}{"boolValue": v}}
+	default:
+		return otlpAttribute{key, map[string]interface{}{"stringValue": fmt.Sprint(v)}}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + parentID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + parentID + "-00", true, false},
+		{"other flags", "00-" + traceID + "-" + parentID + "-03", true, true},
+		{"surrounding space", " 00-" + traceID + "-" + parentID + "-01 ", true, true},
+		{"future version with more fields", "cc-" + traceID + "-" + parentID + "-01-what-the-future-holds", true, true},
+		{"version 00 with more fields", "00-" + traceID + "-" + parentID + "-01-extra", false, false},
+		{"invalid version", "ff-" + traceID + "-" + parentID + "-01", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + parentID + "-01", false, false},
+		{"zero parent ID", "00-" + traceID + "-0000000000000000-01", false, false},
+		{"short trace ID", "00-" + traceID[2:] + "-" + parentID + "-01", false, false},
+		{"not hex", "00-" + strings.Repeat("z", 32) + "-" + parentID + "-01", false, false},
+		{"bad flags", "00-" + traceID + "-" + parentID + "-zz", false, false},
+		{"too few fields", "00-" + traceID + "-" + parentID, false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTrace, gotParent, sampled, ok := parseTraceparent(tt.value)
+			if ok != tt.ok || sampled != tt.sampled {
+				t.Fatalf("ok %v, sampled %v; want %v, %v", ok, sampled, tt.ok, tt.sampled)
+			}
+			if ok && (hex.EncodeToString(gotTrace[:]) != traceID || hex.EncodeToString(gotParent[:]) != parentID) {
+				t.Errorf("trace %x, parent %x", gotTrace, gotParent)
+			}
+		})
+	}
+}
+
+// Queue spans for the length of a test instead of running the exporter
+func useTraceQueue(t *testing.T) {
+	t.Helper()
+	saved := traceQueue
+	traceQueue = make(chan *span, traceQueueSize)
+	t.Cleanup(func() { traceQueue = saved })
+}
+
+func queuedSpans() []*span {
+	var batch []*span
+	for {
+		select {
+		case s := <-traceQueue:
+			batch = append(batch, s)
+		default:
+			return batch
+		}
+	}
+}
+
+// A request traced end to end and written by the file exporter: the caller's
+// trace is continued, and the handler and store calls are its children
+func TestTracedRequestWrittenToFile(t *testing.T) {
+	useTestData(t)
+	useRateLimits(t, map[string]RateLimit{})
+	router := newRouter()
+
+	login := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"username":"admin","password":"admin"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, login)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+
+	useTraceQueue(t)
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/todos", strings.NewReader(`{"text":"Trace me","user":"alice"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writerExporter(f)(queuedSpans()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 1 {
+		t.Fatalf("wrote %d lines, want one OTLP document for the batch", len(lines))
+	}
+	var doc struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.ResourceSpans) != 1 || len(doc.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("document %s", lines[0])
+	}
+	if attrs := doc.ResourceSpans[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value["stringValue"] != serviceName {
+		t.Errorf("resource attributes %+v", attrs)
+	}
+
+	byName := make(map[string]otlpSpan)
+	for _, s := range doc.ResourceSpans[0].ScopeSpans[0].Spans {
+		if s.TraceID != traceID {
+			t.Errorf("span %s in trace %s, want the caller's trace", s.Name, s.TraceID)
+		}
+		byName[s.Name] = s
+	}
+	parents := map[string]string{
+		"POST /api/v1/todos":       "",
+		"authMiddleware":           "POST /api/v1/todos",
+		"store.findUserByID":       "authMiddleware",
+		"addTodo":                  "POST /api/v1/todos",
+		"store.findUserByUsername": "addTodo",
+		"store.insertTodos":        "addTodo",
+	}
+	for name, parent := range parents {
+		s, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s span; got %v", name, lines[0])
+			continue
+		}
+		want := parentID
+		if parent != "" {
+			want = byName[parent].SpanID
+		}
+		if s.ParentSpanID != want {
+			t.Errorf("%s has parent %s, want %s (%s)", name, s.ParentSpanID, want, parent)
+		}
+	}
+	if s := byName["POST /api/v1/todos"]; s.Kind != spanServer {
+		t.Errorf("server span kind %d", s.Kind)
+	}
+	if s := byName["store.insertTodos"]; !hasAttr(s, "todo.count", map[string]interface{}{"intValue": "1"}) || !hasAttr(s, "db.system", map[string]interface{}{"stringValue": "memory"}) {
+		t.Errorf("insertTodos attributes %+v", s.Attributes)
+	}
+}
+
+func hasAttr(s otlpSpan, key string, value map[string]interface{}) bool {
+	for _, attr := range s.Attributes {
+		if attr.Key == key {
+			for k, v := range value {
+				if attr.Value[k] != v {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"to-do-list/api"
+)
+
+// How long deleted todos and users stay in the trash before they are purged.
+// Zero keeps them until they are purged by hand.
+var trashRetention = 30 * 24 * time.Hour
+
+// Load the retention period from TRASH_RETENTION, e.g. "720h" or "0"
+func loadTrashRetention() time.Duration {
+	value := os.Getenv("TRASH_RETENTION")
+	if value == "" {
+		return trashRetention
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention < 0 {
+		slog.Warn("Invalid TRASH_RETENTION", "value", value, "using", trashRetention)
+		return trashRetention
+	}
+	return retention
+}
+
+func isExpired(deletedAt string, now time.Time) bool {
+	if trashRetention == 0 || deletedAt == "" {
+		return false
+	}
+	t, err := time.ParseInLocation(timestampFormat, deletedAt, time.Local)
+	if err != nil {
+		return false
+	}
+	return now.Sub(t) > trashRetention
+}
+
+// Permanently remove trashed items older than the retention period. Called from
+// the handlers that touch the trash, so no background worker is needed.
+func purgeExpiredTrash(ctx context.Context) {
+	s := startStoreSpan(ctx, "purgeExpiredTrash")
+	defer s.finish()
+	now := time.Now()
+
+	var remainingTodos []Todo
+	purged := make(map[int]bool)
+	for _, todo := range todos {
+		if isExpired(todo.DeletedAt, now) {
+			purged[todo.ID] = true
+		} else {
+			remainingTodos = append(remainingTodos, todo)
+		}
+	}
+	s.setAttr("todo.purged", len(purged))
+	todos = remainingTodos
+	purgeTodoComments(purged)
+
+	var expiredUsers []int
+	for _, user := range users {
+		if isExpired(user.DeletedAt, now) {
+			expiredUsers = append(expiredUsers, user.ID)
+		}
+	}
+	for _, id := range expiredUsers {
+		purgeUserRecord(id)
+	}
+	s.setAttr("user.purged", len(expiredUsers))
+}
+
+func findDeletedUser(id int) *User {
+	for i, u := range users {
+		if u.ID == id && u.DeletedAt != "" {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// GET /todos/trash — List deleted todos the caller may restore
+func getTodoTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	dataMu.Lock()
+	purgeExpiredTrash(r.Context())
+	trashed := []Todo{}
+	for _, todo := range todos {
+		if todo.DeletedAt != "" && canActOnTodo(r, todo.UserID, PermTodosDeleteOwn, PermTodosDeleteAny) {
+			trashed = append(trashed, todo)
+		}
+	}
+	dataMu.Unlock()
+
+	json.NewEncoder(w).Encode(trashed)
+}
+
+// POST /todos/{id}/restore — Restore a todo from the trash
+func restoreTodo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid ID")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	purgeExpiredTrash(r.Context())
+
+	for i, todo := range todos {
+		if todo.ID == id && todo.DeletedAt != "" {
+			if !canActOnTodo(r, todo.UserID, PermTodosDeleteOwn, PermTodosDeleteAny) {
+				writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden - cannot restore this todo")
+				return
+			}
+			if todo.DeletedWithUser || findDeletedUser(todo.UserID) != nil {
+				writeError(w, r, http.StatusConflict, api.CodeConflict, "This todo belongs to a deleted user. Restore the user first.")
+				return
+			}
+			todos[i].DeletedAt = ""
+			publishTodo(EventTodoCreated, todos[i])
+			json.NewEncoder(w).Encode(todos[i])
+			return
+		}
+	}
+
+	writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Todo not found in trash")
+}
+
+// DELETE /todos/trash/{id} — Permanently delete a trashed todo
+func purgeTodo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid ID")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	for i, todo := range todos {
+		if todo.ID == id && todo.DeletedAt != "" {
+			if !canActOnTodo(r, todo.UserID, PermTodosDeleteOwn, PermTodosDeleteAny) {
+				writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden - cannot delete this todo")
+				return
+			}
+			todos = append(todos[:i], todos[i+1:]...)
+			purgeTodoComments(map[int]bool{id: true})
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Todo not found in trash")
+}
+
+// GET /admin/users/trash — List deleted users
+func getUserTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	dataMu.Lock()
+	purgeExpiredTrash(r.Context())
+
+	// Return users without passwords
+	trashed := []map[string]interface{}{}
+	for _, user := range users {
+		if user.DeletedAt == "" {
+			continue
+		}
+		todoCount := 0
+		for _, todo := range todos {
+			if todo.UserID == user.ID && todo.DeletedWithUser {
+				todoCount++
+			}
+		}
+		trashed = append(trashed, map[string]interface{}{
+			"id":         user.ID,
+			"username":   user.Username,
+			"role":       user.Role,
+			"deleted_at": user.DeletedAt,
+			"todo_count": todoCount,
+		})
+	}
+	dataMu.Unlock()
+
+	json.NewEncoder(w).Encode(trashed)
+}
+
+// POST /admin/users/{id}/restore — Restore a user and the todos deleted with them
+func restoreUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid user ID")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	purgeExpiredTrash(r.Context())
+
+	user := findDeletedUser(userID)
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "User not found in trash")
+		return
+	}
+	if !roleExists(user.Role) {
+		user.Role = "user"
+	}
+	user.DeletedAt = ""
+	publishUser(EventUserCreated, *user)
+
+	restored := 0
+	for i, todo := range todos {
+		if todo.UserID == user.ID && todo.DeletedWithUser {
+			todos[i].DeletedAt = ""
+			todos[i].DeletedWithUser = false
+			publishTodo(EventTodoCreated, todos[i])
+			restored++
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "User restored successfully",
+		"restored_todos": restored,
+	})
+}
+
+// DELETE /admin/users/trash/{id} — Permanently delete a trashed user and their trashed todos
+func purgeUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid user ID")
+		return
+	}
+
+	dataMu.Lock()
+	defer dataMu.Unlock()
+
+	user := findDeletedUser(userID)
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "User not found in trash")
+		return
+	}
+	purgeUserRecord(userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User permanently deleted"})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"to-do-list/api"
+)
+
+const (
+	maxTodoLength      = 500
+	maxUsernameLen     = 30
+	maxDisplayNameLen  = 60
+	maxEmailLength     = 254
+	maxAvatarURLLength = 2048
+	maxTodoBodySize    = 16 << 10 // POST /todos and comments; far more than the longest valid body
+)
+
+// Constraints on one string field. Trim and NFC are applied to the value in
+// place, then the rules are checked in order and the first failure is reported.
+type stringRule struct {
+	Label     string // Names the field in messages, e.g. "Text"
+	Trim      bool
+	NFC       bool // Normalize to Unicode NFC, so the same name typed on different systems compares equal
+	Required  bool
+	MaxLength int                 // In characters, 0 for no limit
+	Check     func(string) string // Further checks on non-empty values, returning a message or ""
+}
+
+func (rule stringRule) validate(value *string) string {
+	if rule.Trim {
+		*value = strings.TrimSpace(*value)
+	}
+	if rule.NFC {
+		*value = norm.NFC.String(*value)
+	}
+	if *value == "" {
+		if rule.Required {
+			return rule.Label + " is required"
+		}
+		return ""
+	}
+	if rule.MaxLength > 0 && len([]rune(*value)) > rule.MaxLength {
+		return fmt.Sprintf("%s must be %d characters or less", rule.Label, rule.MaxLength)
+	}
+	if rule.Check != nil {
+		return rule.Check(*value)
+	}
+	return ""
+}
+
+// A rule bound to a field of a decoded request. A nil value is a field that
+// was left out of a partial update and is skipped.
+type fieldRule struct {
+	field string // JSON name
+	value *string
+	rule  stringRule
+}
+
+type fieldRules []fieldRule
+
+// Apply every rule and return an error for each field that fails
+func (rules fieldRules) validate() []api.FieldError {
+	var errs []api.FieldError
+	for _, f := range rules {
+		if f.value == nil {
+			continue
+		}
+		if msg := f.rule.validate(f.value); msg != "" {
+			errs = append(errs, api.FieldError{Field: f.field, Code: api.CodeValidationFailed, Message: msg})
+		}
+	}
+	return errs
+}
+
+var (
+	todoTextRule    = stringRule{Label: "Text", Trim: true, Required: true, MaxLength: maxTodoLength}
+	assigneeRule    = stringRule{Label: "User", Trim: true, Check: checkAssignee}
+	dueDateRule     = stringRule{Label: "Due date", Trim: true, Check: checkDueDate}
+	commentRule     = stringRule{Label: "Comment text", Trim: true, Required: true, MaxLength: maxCommentLength}
+	usernameRule    = stringRule{Label: "Username", Trim: true, NFC: true, Required: true, MaxLength: maxUsernameLen, Check: checkUsername}
+	displayNameRule = stringRule{Label: "Display name", Trim: true, NFC: true, MaxLength: maxDisplayNameLen, Check: checkDisplayName}
+	emailRule       = stringRule{Label: "Email", Trim: true, MaxLength: maxEmailLength, Check: checkEmail}
+	avatarURLRule   = stringRule{Label: "Avatar URL", Trim: true, MaxLength: maxAvatarURLLength, Check: checkAvatarURL}
+)
+
+// Todos can only be assigned to active users
+func checkAssignee(username string) string {
+	if findUserByUsername(context.Background(), username) == nil {
+		return fmt.Sprintf("Unknown user %q", username)
+	}
+	return ""
+}
+
+func checkDueDate(date string) string {
+	if _, err := time.Parse(dueDateFormat, date); err != nil {
+		return "Due date must be in YYYY-MM-DD format"
+	}
+	return ""
+}
+
+// Letters and digits in any script, plus '.', '-' and '_'
+func checkUsername(username string) string {
+	for _, char := range username {
+		if unicode.IsSpace(char) {
+			return "Username cannot contain spaces"
+		}
+		if !isUsernameChar(char) {
+			return "Username can only contain letters, numbers, '.', '-' and '_'"
+		}
+	}
+	return ""
+}
+
+func isUsernameChar(char rune) bool {
+	// Marks combine with the letter before them in many scripts
+	return unicode.IsLetter(char) || unicode.IsDigit(char) || unicode.IsMark(char) || char == '.' || char == '-' || char == '_'
+}
+
+// Any printable text, including spaces between words
+func checkDisplayName(name string) string {
+	for _, char := range name {
+		if !unicode.IsGraphic(char) {
+			return "Display name cannot contain control characters"
+		}
+	}
+	return ""
+}
+
+func checkEmail(email string) string {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "Email must be an address like name@example.com"
+	}
+	return ""
+}
+
+func checkAvatarURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "Avatar URL must be an http or https URL"
+	}
+	return ""
+}
+
+// Usernames are unique regardless of case, so "Zoë" and "zoë" are the same user
+func sameUsername(a, b string) bool {
+	return strings.EqualFold(norm.NFC.String(a), norm.NFC.String(b))
+}
+
+// The user, trashed or not, other than exceptID whose username matches regardless of case
+func usernameOwner(username string, exceptID int) *User {
+	for i, u := range users {
+		if u.ID != exceptID && sameUsername(u.Username, username) {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// The user, trashed or not, other than exceptID with this email address
+func emailOwner(email string, exceptID int) *User {
+	if email == "" {
+		return nil
+	}
+	for i, u := range users {
+		if u.ID != exceptID && strings.EqualFold(u.Email, email) {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// Rules for the body of POST /todos
+func newTodoRules(req *api.NewTodo) fieldRules {
+	return fieldRules{
+		{"text", &req.Text, todoTextRule},
+		{"user", &req.User, assigneeRule},
+		{"due_date", &req.DueDate, dueDateRule},
+	}
+}
+
+// Rules for the body of POST /admin/users
+func newUserRules(req *api.CreateUserRequest) fieldRules {
+	return fieldRules{
+		{"username", &req.Username, usernameRule},
+		{"display_name", &req.DisplayName, displayNameRule},
+		{"email", &req.Email, emailRule},
+		{"avatar_url", &req.AvatarURL, avatarURLRule},
+	}
+}
+
+// Rules for the body of PUT /admin/users/{id}
+func updateUserRules(req *api.UpdateUserRequest) fieldRules {
+	return fieldRules{
+		{"username", &req.Username, usernameRule},
+		{"display_name", req.DisplayName, displayNameRule},
+		{"email", req.Email, emailRule},
+		{"avatar_url", req.AvatarURL, avatarURLRule},
+	}
+}
+
+// Decode a JSON request body, writing the error response when it can't be.
+// Bodies over the route's limit get a 413 and values of the wrong type a field error.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, api.CodePayloadTooLarge, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		writeFieldError(w, r, typeErr.Field, fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind())))
+	default:
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+	}
+	return false
+}
+
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "an object"
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUsernameLookupsIgnoreCase(t *testing.T) {
+	useTestData(t)
+	users = append(users, User{ID: 5, Username: "Zoë", Email: "zoe@example.com", Role: "user"})
+	users[2].DeletedAt = "2026-01-01 00:00:00"
+
+	tests := []struct {
+		name   string
+		wantID int
+	}{
+		{"alice", 2},
+		{"ALICE", 2},
+		{"zoë", 5},
+		{"Zoe\u0308", 5}, // Decomposed ë
+		{"bob", 0},       // In the trash
+		{"dave", 0},
+	}
+	for _, tt := range tests {
+		gotID := 0
+		if user := findUserByUsername(context.Background(), tt.name); user != nil {
+			gotID = user.ID
+		}
+		if gotID != tt.wantID {
+			t.Errorf("findUserByUsername(%q) = user %d, want %d", tt.name, gotID, tt.wantID)
+		}
+		gotID = 0
+		if user := resolveImportUser(context.Background(), " "+tt.name+" "); user != nil {
+			gotID = user.ID
+		}
+		if gotID != tt.wantID {
+			t.Errorf("resolveImportUser(%q) = user %d, want %d", tt.name, gotID, tt.wantID)
+		}
+	}
+	if user := resolveImportUser(context.Background(), "ZOE@example.com"); user == nil || user.ID != 5 {
+		t.Errorf("resolveImportUser by email = %v, want user 5", user)
+	}
+}
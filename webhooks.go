@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"to-do-list/api"
+)
+
+// Todo lifecycle events that webhooks can subscribe to
+const (
+	WebhookTodoCreated   = "todo.created"
+	WebhookTodoCompleted = "todo.completed"
+	WebhookTodoDeleted   = "todo.deleted"
+)
+
+var webhookEvents = []string{
+	WebhookTodoCreated,
+	WebhookTodoCompleted,
+	WebhookTodoDeleted,
+}
+
+const (
+	webhookMaxAttempts   = 6
+	webhookInitialDelay  = 2 * time.Second // Doubled after every failed attempt
+	webhookMaxDelay      = 5 * time.Minute
+	webhookTimeout       = 10 * time.Second
+	webhookWorkers       = 4
+	webhookQueueSize     = 256
+	webhookDeliveryLimit = 1000 // Deliveries kept in the log
+)
+
+type Webhook struct {
+	ID        int      `json:"id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      string          `json:"created_at"`
+	LastAttemptAt  string          `json:"last_attempt_at,omitempty"`
+	NextAttemptAt  string          `json:"next_attempt_at,omitempty"`
+	RedeliveryOf   int             `json:"redelivery_of,omitempty"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// Webhooks and their delivery log share one lock; deliveries are read by the workers
+var (
+	webhooks          []Webhook
+	webhookDeliveries []*WebhookDelivery
+	nextWebhookID     = 1
+	nextDeliveryID    = 1
+	webhooksMu        sync.Mutex
+
+	webhookQueue  = make(chan int, webhookQueueSize)
+	webhookClient = &http.Client{Timeout: webhookTimeout}
+)
+
+// Start the background workers that deliver queued webhooks
+func startWebhookWorkers() {
+	for i := 0; i < webhookWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case id := <-webhookQueue:
+					deliverWebhook(id)
+				case <-stopWorkers:
+					// Queued deliveries stay pending; a delivery in progress finishes first
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Queue a todo event for every active webhook subscribed to it. Called by the
+// todo handlers right after they change state.
+func emitWebhook(event string, todo Todo) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":     event,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"data":      todo,
+	})
+	if err != nil {
+		slog.Error("Webhook payload failed", "event", event, "error", err)
+		return
+	}
+
+	webhooksMu.Lock()
+	var queued []int
+	for _, hook := range webhooks {
+		if hook.Active && containsString(hook.Events, event) {
+			queued = append(queued, newDelivery(hook.ID, event, payload, 0).ID)
+		}
+	}
+	webhooksMu.Unlock()
+
+	for _, id := range queued {
+		enqueueDelivery(id)
+	}
+}
+
+// Add a pending delivery to the log; the caller holds webhooksMu
+func newDelivery(webhookID int, event string, payload json.RawMessage, redeliveryOf int) *WebhookDelivery {
+	delivery := &WebhookDelivery{
+		ID:           nextDeliveryID,
+		WebhookID:    webhookID,
+		Event:        event,
+		Payload:      payload,
+		Status:       DeliveryPending,
+		CreatedAt:    time.Now().Format(timestampFormat),
+		RedeliveryOf: redeliveryOf,
+	}
+	nextDeliveryID++
+
+	webhookDeliveries = append(webhookDeliveries, delivery)
+	if len(webhookDeliveries) > webhookDeliveryLimit {
+		dropped := webhookDeliveries[:len(webhookDeliveries)-webhookDeliveryLimit]
+		for _, d := range dropped {
+			// Deliveries that leave the log are never attempted again
+			if d.Status == DeliveryPending {
+				slog.Warn("Pending webhook delivery dropped from the full delivery log",
+					"delivery_id", d.ID, "webhook_id", d.WebhookID, "event", d.Event, "attempts", d.Attempts)
+			}
+		}
+		webhookDeliveries = webhookDeliveries[len(webhookDeliveries)-webhookDeliveryLimit:]
+	}
+	return delivery
+}
+
+// Hand a delivery to the workers without blocking the request; a full queue is
+// treated like a failed attempt and retried later. Once the workers are stopping
+// nothing is queued or rescheduled, and the delivery stays pending.
+func enqueueDelivery(id int) {
+	select {
+	case <-stopWorkers:
+		return
+	default:
+	}
+	select {
+	case webhookQueue <- id:
+	default:
+		time.AfterFunc(webhookInitialDelay, func() { enqueueDelivery(id) })
+	}
+}
+
+func findDelivery(id int) *WebhookDelivery {
+	for _, d := range webhookDeliveries {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+func findWebhook(id int) *Webhook {
+	for i, hook := range webhooks {
+		if hook.ID == id {
+			return &webhooks[i]
+		}
+	}
+	return nil
+}
+
+// Sign "<timestamp>.<body>" so receivers can reject replayed requests
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Delay before the next attempt after the given number of failed attempts
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookInitialDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= webhookMaxDelay {
+			return webhookMaxDelay
+		}
+	}
+	return delay
+}
+
+// Make one delivery attempt and schedule a retry if it fails
+func deliverWebhook(id int) {
+	webhooksMu.Lock()
+	delivery := findDelivery(id)
+	if delivery == nil || delivery.Status != DeliveryPending {
+		webhooksMu.Unlock()
+		return
+	}
+	hook := findWebhook(delivery.WebhookID)
+	if hook == nil || !hook.Active {
+		delivery.Status = DeliveryFailed
+		delivery.Error = "Webhook was deleted or disabled"
+		delivery.NextAttemptAt = ""
+		webhooksMu.Unlock()
+		return
+	}
+	target, secret, event, payload := hook.URL, hook.Secret, delivery.Event, delivery.Payload
+	webhooksMu.Unlock()
+
+	status, err := postWebhook(target, secret, event, id, payload)
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = time.Now().Format(timestampFormat)
+	delivery.ResponseStatus = status
+	delivery.NextAttemptAt = ""
+	if err == nil {
+		delivery.Status = DeliverySucceeded
+		delivery.Error = ""
+		return
+	}
+
+	delivery.Error = err.Error()
+	if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = DeliveryFailed
+		return
+	}
+	delay := webhookBackoff(delivery.Attempts)
+	delivery.NextAttemptAt = time.Now().Add(delay).Format(timestampFormat)
+	time.AfterFunc(delay, func() { enqueueDelivery(id) })
+}
+
+func postWebhook(target, secret, event string, deliveryID int, payload []byte) (int, error) {
+	ctx, span := startSpan(context.Background(), "webhook delivery", spanClient)
+	defer span.finish()
+	span.setAttr("webhook.event", event)
+	span.setAttr("webhook.delivery_id", deliveryID)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	injectTraceContext(ctx, req.Header)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Team-To-Do-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(secret, timestamp, payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		span.setError(err.Error())
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	span.setAttr("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		span.setError(resp.Status)
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validateWebhook(hook Webhook) string {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "URL must be an absolute http or https URL"
+	}
+	if len(hook.Events) == 0 {
+		return fmt.Sprintf("At least one event is required: %s", strings.Join(webhookEvents, ", "))
+	}
+	for _, event := range hook.Events {
+		if !containsString(webhookEvents, event) {
+			return fmt.Sprintf("Unknown event %q", event)
+		}
+	}
+	return ""
+}
+
+// Webhooks are listed without their secret
+func redactWebhook(hook Webhook) Webhook {
+	hook.Secret = ""
+	return hook
+}
+
+// GET /admin/webhooks — List webhook subscriptions
+func getWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	webhooksMu.Lock()
+	list := []Webhook{}
+	for _, hook := range webhooks {
+		list = append(list, redactWebhook(hook))
+	}
+	webhooksMu.Unlock()
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// POST /admin/webhooks — Subscribe a URL to todo events
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	hook := Webhook{
+		URL:       strings.TrimSpace(req.URL),
+		Secret:    req.Secret,
+		Events:    req.Events,
+		Active:    req.Active == nil || *req.Active,
+		CreatedAt: time.Now().Format(timestampFormat),
+	}
+	if msg := validateWebhook(hook); msg != "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeValidationFailed, msg)
+		return
+	}
+	// Generate a secret when none is given; it is only shown in this response
+	if hook.Secret == "" {
+		hook.Secret = randomToken(32)
+	}
+
+	webhooksMu.Lock()
+	hook.ID = nextWebhookID
+	nextWebhookID++
+	webhooks = append(webhooks, hook)
+	webhooksMu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// PUT /admin/webhooks/{id} — Update a webhook's URL, secret, events or active flag
+func updateWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid webhook ID")
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	hook := findWebhook(id)
+	if hook == nil {
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Webhook not found")
+		return
+	}
+
+	// Only change the fields that were provided
+	updated := *hook
+	if req.URL != "" {
+		updated.URL = strings.TrimSpace(req.URL)
+	}
+	if req.Secret != "" {
+		updated.Secret = req.Secret
+	}
+	if req.Events != nil {
+		updated.Events = req.Events
+	}
+	if req.Active != nil {
+		updated.Active = *req.Active
+	}
+	if msg := validateWebhook(updated); msg != "" {
+		writeError(w, r, http.StatusBadRequest, api.CodeValidationFailed, msg)
+		return
+	}
+	*hook = updated
+
+	json.NewEncoder(w).Encode(redactWebhook(updated))
+}
+
+// DELETE /admin/webhooks/{id} — Remove a webhook; pending retries are abandoned
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid webhook ID")
+		return
+	}
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	for i, hook := range webhooks {
+		if hook.ID == id {
+			webhooks = append(webhooks[:i], webhooks[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Webhook not found")
+}
+
+// GET /admin/webhooks/{id}/deliveries — Delivery log for a webhook, newest first
+func getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid webhook ID")
+		return
+	}
+
+	// Optional ?status=pending|succeeded|failed filter
+	status := r.URL.Query().Get("status")
+
+	webhooksMu.Lock()
+	if findWebhook(id) == nil {
+		webhooksMu.Unlock()
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Webhook not found")
+		return
+	}
+	list := []WebhookDelivery{}
+	for _, d := range webhookDeliveries {
+		if d.WebhookID == id && (status == "" || d.Status == status) {
+			list = append(list, *d)
+		}
+	}
+	webhooksMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	json.NewEncoder(w).Encode(list)
+}
+
+// POST /admin/webhooks/deliveries/{id}/redeliver — Send a logged payload again as a new delivery
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8080")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, api.CodeInvalidID, "Invalid delivery ID")
+		return
+	}
+
+	webhooksMu.Lock()
+	original := findDelivery(id)
+	if original == nil {
+		webhooksMu.Unlock()
+		writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Delivery not found")
+		return
+	}
+	if findWebhook(original.WebhookID) == nil {
+		webhooksMu.Unlock()
+		writeError(w, r, http.StatusGone, api.CodeGone, "Webhook no longer exists")
+		return
+	}
+	delivery := newDelivery(original.WebhookID, original.Event, original.Payload, original.ID)
+	response := *delivery
+	webhooksMu.Unlock()
+
+	enqueueDelivery(delivery.ID)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// Swap in an empty delivery log and queue for the length of a test
+func useWebhookDeliveries(t *testing.T) {
+	t.Helper()
+	savedDeliveries, savedNextID, savedQueue := webhookDeliveries, nextDeliveryID, webhookQueue
+	t.Cleanup(func() {
+		webhookDeliveries, nextDeliveryID, webhookQueue = savedDeliveries, savedNextID, savedQueue
+	})
+	webhookDeliveries, nextDeliveryID = nil, 1
+	webhookQueue = make(chan int, webhookQueueSize)
+}
+
+func TestNoDeliveriesQueuedAfterShutdown(t *testing.T) {
+	useWebhookDeliveries(t)
+	savedStop := stopWorkers
+	t.Cleanup(func() { stopWorkers = savedStop })
+	stopWorkers = make(chan struct{})
+
+	enqueueDelivery(1)
+	if len(webhookQueue) != 1 {
+		t.Fatalf("queue holds %d deliveries, want 1", len(webhookQueue))
+	}
+	<-webhookQueue
+
+	close(stopWorkers)
+	enqueueDelivery(2)
+	if len(webhookQueue) != 0 {
+		t.Errorf("a delivery was queued after the workers stopped")
+	}
+}
+
+func TestPendingDeliveriesDroppedFromTheLogAreLogged(t *testing.T) {
+	useWebhookDeliveries(t)
+	var logged bytes.Buffer
+	saved := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(saved) })
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logged, nil)))
+
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+	first := newDelivery(1, WebhookTodoCreated, json.RawMessage(`{}`), 0)
+	second := newDelivery(1, WebhookTodoCreated, json.RawMessage(`{}`), 0)
+	second.Status = DeliverySucceeded
+	for i := 2; i < webhookDeliveryLimit; i++ {
+		newDelivery(1, WebhookTodoCreated, json.RawMessage(`{}`), 0)
+	}
+	if logged.Len() != 0 {
+		t.Fatalf("logged before the log was full: %s", logged.String())
+	}
+
+	newDelivery(1, WebhookTodoCreated, json.RawMessage(`{}`), 0) // Drops the pending first delivery
+	newDelivery(1, WebhookTodoCreated, json.RawMessage(`{}`), 0) // Drops the finished second delivery
+	if len(webhookDeliveries) != webhookDeliveryLimit || findDelivery(first.ID) != nil || findDelivery(second.ID) != nil {
+		t.Fatalf("log holds %d deliveries, want the newest %d", len(webhookDeliveries), webhookDeliveryLimit)
+	}
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want one for the pending delivery: %s", len(lines), logged.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["level"] != "WARN" || entry["delivery_id"] != float64(first.ID) {
+		t.Errorf("logged %s", lines[0])
+	}
+}